@@ -2,11 +2,15 @@ package main
 
 import (
 	"bytes"
+	"context"
 	"crypto/sha256"
 	"fmt"
 	"httpfromtcp/internal/headers"
+	"httpfromtcp/internal/metrics"
+	"httpfromtcp/internal/middleware"
 	"httpfromtcp/internal/request"
 	"httpfromtcp/internal/response"
+	"httpfromtcp/internal/router"
 	"httpfromtcp/internal/server"
 	"log"
 	"net/http"
@@ -15,39 +19,59 @@ import (
 	"strconv"
 	"strings"
 	"syscall"
+	"time"
 )
 
 const port = 42069
+const shutdownTimeout = 10 * time.Second
 
 func main() {
-	server, err := server.Serve(port, handler)
+	reg := metrics.NewRegistry()
+	cfg := server.DefaultConfig()
+	cfg.Metrics = server.NewMetrics(reg)
+
+	rt := router.New()
+	rt.Handle("GET", "/metrics", metrics.Handler(reg))
+	rt.Handle("GET", "/httpbin/{path...}", proxyHandler)
+	rt.Handle("GET", "/yourproblem", handler400)
+	rt.Handle("GET", "/myproblem", handler500)
+	rt.Handle("GET", "/{path...}", handler200)
+
+	handler := middleware.Chain(rt.ServeRequest,
+		middleware.Logging(log.Default()),
+		middleware.Recover(log.Default()),
+		middleware.RequestID(),
+	)
+	// Use the sockets systemd passed in, if any, rather than the fixed port
+	listeners, err := server.SystemdListeners()
 	if err != nil {
 		log.Fatalf("Error starting server: %v", err)
 	}
-	defer server.Close()
-	log.Println("Server started on port", port)
+	srv := server.New(handler, cfg)
+	if len(listeners) == 0 {
+		err = srv.Listen("tcp", fmt.Sprintf(":%d", port))
+	}
+	for _, l := range listeners {
+		if err == nil {
+			err = srv.ServeListener(l)
+		}
+	}
+	if err != nil {
+		log.Fatalf("Error starting server: %v", err)
+	}
+	log.Println("Server listening on", srv.Addrs())
 
 	sigChan := make(chan os.Signal, 1)
 	signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM)
 	<-sigChan
-	log.Println("Server gracefully stopped")
-}
 
-func handler(w *response.Writer, req *request.Request) {
-	if strings.HasPrefix(req.RequestLine.RequestTarget, "/httpbin") {
-		proxyHandler(w, req)
-		return
-	}
-	if req.RequestLine.RequestTarget == "/yourproblem" {
-		handler400(w, req)
-		return
-	}
-	if req.RequestLine.RequestTarget == "/myproblem" {
-		handler500(w, req)
+	ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+	if err := srv.Shutdown(ctx); err != nil {
+		log.Printf("Error shutting down server: %v", err)
 		return
 	}
-	handler200(w, req)
-	return
+	log.Println("Server gracefully stopped")
 }
 
 func handler400(w *response.Writer, _ *request.Request) {
@@ -63,7 +87,7 @@ func handler400(w *response.Writer, _ *request.Request) {
 		</html>
 		`)
 	h := response.GetDefaultHeaders(len(body))
-	h.Override("Content-Type", "text/html")
+	h.Set("Content-Type", "text/html")
 	w.WriteHeaders(h)
 	w.WriteBody(body)
 	return
@@ -82,7 +106,7 @@ func handler500(w *response.Writer, _ *request.Request) {
 		</html>
 		`)
 	h := response.GetDefaultHeaders(len(body))
-	h.Override("Content-Type", "text/html")
+	h.Set("Content-Type", "text/html")
 	w.WriteHeaders(h)
 	w.WriteBody(body)
 	return
@@ -101,17 +125,26 @@ func handler200(w *response.Writer, _ *request.Request) {
 		</html>
 		`)
 	h := response.GetDefaultHeaders(len(body))
-	h.Override("Content-Type", "text/html")
+	h.Set("Content-Type", "text/html")
 	w.WriteHeaders(h)
 	w.WriteBody(body)
 	return
 }
 
 func proxyHandler(w *response.Writer, req *request.Request) {
-	path := strings.TrimPrefix(req.RequestLine.RequestTarget, "/httpbin")
-	url := "https://httpbin.org" + path
+	target := req.RequestLine.Target
+	url := "https://httpbin.org" + strings.TrimPrefix(target.RawPath, "/httpbin")
+	if target.RawQuery != "" {
+		url += "?" + target.RawQuery
+	}
 	fmt.Println("Proxying to", url)
-	resp, err := http.Get(url)
+	// Stop proxying if the client goes away or the server shuts down
+	upstreamReq, err := http.NewRequestWithContext(req.Context(), "GET", url, nil)
+	if err != nil {
+		handler500(w, req)
+		return
+	}
+	resp, err := http.DefaultClient.Do(upstreamReq)
 	if err != nil {
 		fmt.Printf("error accessing server: %v\n", err)
 		handler500(w, req)
@@ -121,10 +154,10 @@ func proxyHandler(w *response.Writer, req *request.Request) {
 
 	w.WriteStatusLine(response.StatusCodeSuccess)
 	h := response.GetDefaultHeaders(0)
-	h.Set("Trailer", "X-Content-SHA256")
-	h.Set("Trailer", "X-Content-Length")
-	h.Remove("Content-Length")
-	h.Override("Transfer-Encoding", "chunked")
+	h.Add("Trailer", "X-Content-SHA256")
+	h.Add("Trailer", "X-Content-Length")
+	h.Del("Content-Length")
+	h.Set("Transfer-Encoding", "chunked")
 	w.WriteHeaders(h)
 
 	const maxChunkSize = 1024
@@ -3,6 +3,7 @@ package main
 import (
 	"fmt"
 	"httpfromtcp/internal/request"
+	"io"
 	"log"
 	"net"
 )
@@ -34,12 +35,16 @@ func main() {
 		fmt.Printf("- Version: %s\n", req.RequestLine.HttpVersion)
 
 		fmt.Println("Headers:")
-		for key, value := range req.Headers {
-			fmt.Printf("- %s: %s\n", key, value)
+		for _, f := range req.Headers.Fields() {
+			fmt.Printf("- %s: %s\n", f.Name, f.Value)
 		}
 
 		fmt.Println("Body:")
-		fmt.Println(string(req.Body))
+		body, err := io.ReadAll(req.Body)
+		if err != nil {
+			log.Fatalf("error reading body: %v", err)
+		}
+		fmt.Println(string(body))
 
 		fmt.Printf("Connection to %s closed\n", conn.RemoteAddr())
 		err = conn.Close()
@@ -2,7 +2,9 @@ package headers
 
 import (
 	"bytes"
+	"errors"
 	"fmt"
+	"slices"
 	"strings"
 	"unicode"
 )
@@ -10,13 +12,90 @@ import (
 const crlf = "\r\n"
 const specialChars = "!#$%&'*+-.^_`|~"
 
-type Headers map[string]string
+// Field is a single field line. Name keeps the casing it was received or
+// added with.
+type Field struct {
+	Name  string
+	Value string
+}
+
+// Headers is an ordered list of field lines. Lookups are case-insensitive,
+// repeated fields are kept as separate lines, and iteration follows the
+// order fields were parsed or added in.
+//
+// Like a map, Headers refers to its fields: copies share them, so a change
+// made through one copy is seen through all of them. Use Clone for a copy
+// that is independent of the original. The zero value is empty and ready to
+// use, but only starts sharing once it is written to; NewHeaders returns
+// one that shares from the start.
+type Headers struct {
+	list *fieldList
+}
+
+type fieldList struct {
+	fields []Field
+}
 
 func NewHeaders() Headers {
-	return map[string]string{}
+	return Headers{list: &fieldList{}}
+}
+
+// Clone returns a copy of h that shares no fields with it.
+func (h Headers) Clone() Headers {
+	return Headers{list: &fieldList{fields: slices.Clone(h.all())}}
+}
+
+// all returns the fields for reading, nil for the zero value.
+func (h Headers) all() []Field {
+	if h.list == nil {
+		return nil
+	}
+	return h.list.fields
+}
+
+// shared returns the fields for writing, allocating them for the zero
+// value.
+func (h *Headers) shared() *fieldList {
+	if h.list == nil {
+		h.list = &fieldList{}
+	}
+	return h.list
+}
+
+// Mode selects how strictly Parse follows RFC 9110 and RFC 9112.
+type Mode int
+
+const (
+	// ModeDefault rejects obsolete line folding, whitespace before the
+	// first field name and control characters in field values.
+	ModeDefault Mode = iota
+	// ModeStrict follows the grammar to the letter, for internet-facing
+	// ports: it also rejects non-ASCII bytes in field values and non-ASCII
+	// letters in field names.
+	ModeStrict
+	// ModeLenient is for legacy clients: obsolete line folding is unfolded
+	// into a single space, lines starting with whitespace before the first
+	// field are discarded unparsed, and control characters other than NUL,
+	// CR and LF are accepted in field values.
+	ModeLenient
+)
+
+var (
+	ErrObsoleteLineFolding = errors.New("obsolete line folding in header field")
+	ErrInvalidFieldValue   = errors.New("invalid character in header field value")
+	// ErrLeadingWhitespace is a line starting with whitespace before the
+	// first field. Taking it for a field would let a recipient that ignores
+	// it, as RFC 9112 section 2.2 allows, frame the message differently.
+	ErrLeadingWhitespace = errors.New("whitespace before first header field")
+)
+
+func (h *Headers) Parse(data []byte) (n int, done bool, err error) {
+	return h.ParseMode(data, ModeDefault)
 }
 
-func (h Headers) Parse(data []byte) (n int, done bool, err error) {
+// ParseMode parses a single field line, or the empty line ending the
+// section, using the given strictness.
+func (h *Headers) ParseMode(data []byte, mode Mode) (n int, done bool, err error) {
 	idx := bytes.Index(data, []byte(crlf))
 
 	// Assume not enough data
@@ -31,8 +110,35 @@ func (h Headers) Parse(data []byte) (n int, done bool, err error) {
 
 	headersText := string(data[:idx])
 
+	// A line starting with whitespace continues the previous field
+	// (obs-fold, RFC 9112 section 5.2), or is junk before the first one
+	if headersText[0] == ' ' || headersText[0] == '\t' {
+		if h.Len() > 0 {
+			if mode != ModeLenient {
+				return 0, false, ErrObsoleteLineFolding
+			}
+			continuation := strings.Trim(headersText, " \t")
+			if !validFieldValue(continuation, mode) {
+				return 0, false, fmt.Errorf("%w: %q", ErrInvalidFieldValue, continuation)
+			}
+			if continuation != "" {
+				fields := h.shared().fields
+				last := &fields[len(fields)-1]
+				if last.Value != "" {
+					last.Value += " "
+				}
+				last.Value += continuation
+			}
+			return idx + len(crlf), false, nil
+		}
+		if mode != ModeLenient {
+			return 0, false, ErrLeadingWhitespace
+		}
+		return idx + len(crlf), false, nil
+	}
+
 	// Removes trailing and leading whitespace
-	headersText = strings.TrimSpace(headersText)
+	headersText = strings.Trim(headersText, " \t")
 
 	// Split on first colon
 	colonIdx := strings.Index(headersText, ":")
@@ -42,45 +148,146 @@ func (h Headers) Parse(data []byte) (n int, done bool, err error) {
 
 	fieldName, fieldValue := headersText[:colonIdx], headersText[colonIdx+len(":"):]
 
+	if fieldName == "" {
+		return 0, false, fmt.Errorf("invalid header: empty field name")
+	}
 	if unicode.IsSpace(rune(fieldName[len(fieldName)-1])) {
 		return 0, false, fmt.Errorf("invalid header: whitespace between field name and colon")
 	}
 
-	fieldName = strings.TrimSpace(fieldName)
-	fieldValue = strings.TrimSpace(fieldValue)
+	fieldValue = strings.Trim(fieldValue, " \t")
 
 	// Check for invalid characters in fieldName
-	if !validTokens(fieldName, specialChars) {
+	if !validTokens(fieldName, specialChars) || (mode == ModeStrict && !isASCII(fieldName)) {
 		return 0, false, fmt.Errorf("invalid character found in fieldName")
 	}
+	if !validFieldValue(fieldValue, mode) {
+		return 0, false, fmt.Errorf("%w: %s", ErrInvalidFieldValue, fieldName)
+	}
 
-	fieldName = strings.ToLower(fieldName)
+	h.Add(fieldName, fieldValue)
+	return idx + len(crlf), false, nil
+}
+
+// ValidFieldValue reports whether value may be sent as a field value:
+// field-content is visible characters, spaces, tabs and obs-text, and
+// nothing that could end the line early (RFC 9110 section 5.5).
+func ValidFieldValue(value string) bool {
+	return validFieldValue(value, ModeDefault)
+}
 
-	// Check if map key exists
-	value, ok := h[fieldName]
-	if ok {
-		h[fieldName] = value + ", " + fieldValue
-	} else {
-		// Add it to the map
-		h[fieldName] = fieldValue
+func validFieldValue(value string, mode Mode) bool {
+	for i := 0; i < len(value); i++ {
+		c := value[i]
+		switch {
+		case c == '\t' || (' ' <= c && c < 0x7f):
+		case c >= 0x80:
+			if mode == ModeStrict {
+				return false
+			}
+		case c == 0 || c == '\r' || c == '\n':
+			return false
+		default:
+			// Remaining control characters, including DEL
+			if mode != ModeLenient {
+				return false
+			}
+		}
 	}
-	
-	return idx + len(crlf), false, nil
+	return true
 }
 
+func isASCII(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if s[i] >= 0x80 {
+			return false
+		}
+	}
+	return true
+}
+
+// Get returns every value of key joined with ", ", which is equivalent to
+// the separate field lines for list-based fields (RFC 9110 section 5.3).
+// Use Values for fields such as Set-Cookie that cannot be combined.
 func (h Headers) Get(key string) (string, bool) {
-	key = strings.ToLower(key)
-	v, ok := h[key]
-	return v, ok
+	values := h.Values(key)
+	if len(values) == 0 {
+		return "", false
+	}
+	return strings.Join(values, ", "), true
 }
 
-func (h Headers) Set(key, value string) {
-	key = strings.ToLower(key)
-	v, ok := h[key]
-	if ok {
-		value = strings.Join([]string{v, value,}, ", ")
+// Values returns the value of each field line named key, in order.
+func (h Headers) Values(key string) []string {
+	var values []string
+	for _, f := range h.all() {
+		if strings.EqualFold(f.Name, key) {
+			values = append(values, f.Value)
+		}
 	}
-	h[key] = value
+	return values
+}
+
+// HasToken reports whether the comma-separated value of key contains token,
+// compared case-insensitively (e.g. "Connection: keep-alive, Upgrade").
+func (h Headers) HasToken(key, token string) bool {
+	value, ok := h.Get(key)
+	if !ok {
+		return false
+	}
+	for _, part := range strings.Split(value, ",") {
+		if strings.EqualFold(strings.TrimSpace(part), token) {
+			return true
+		}
+	}
+	return false
+}
+
+// Fields returns a copy of the field lines in order.
+func (h Headers) Fields() []Field {
+	return append([]Field(nil), h.all()...)
+}
+
+func (h Headers) Len() int {
+	return len(h.all())
+}
+
+// Add appends a field line, keeping any existing ones with the same name.
+func (h *Headers) Add(key, value string) {
+	l := h.shared()
+	l.fields = append(l.fields, Field{Name: key, Value: value})
+}
+
+// Set replaces every field line named key with a single one, kept at the
+// position of the first.
+func (h *Headers) Set(key, value string) {
+	l := h.shared()
+	for i, f := range l.fields {
+		if strings.EqualFold(f.Name, key) {
+			l.fields[i].Value = value
+			l.deleteFrom(i+1, key)
+			return
+		}
+	}
+	h.Add(key, value)
+}
+
+func (h *Headers) Del(key string) {
+	if h.list != nil {
+		h.list.deleteFrom(0, key)
+	}
+}
+
+// deleteFrom removes the field lines named key from index start on.
+func (l *fieldList) deleteFrom(start int, key string) {
+	fields := l.fields[:start]
+	for _, f := range l.fields[start:] {
+		if !strings.EqualFold(f.Name, key) {
+			fields = append(fields, f)
+		}
+	}
+	clear(l.fields[len(fields):])
+	l.fields = fields
 }
 
 func validTokens(s string, specialChars string) bool {
@@ -92,12 +299,20 @@ func validTokens(s string, specialChars string) bool {
 	return true
 }
 
-func (h Headers) Override(key string, value string) {
-	key = strings.ToLower(key)
-	h[key] = value
+// CanonicalKey returns the conventional casing of a field name, with the
+// first letter and each letter after a hyphen upper-cased:
+// "content-type" becomes "Content-Type".
+func CanonicalKey(key string) string {
+	b := []byte(key)
+	upper := true
+	for i, c := range b {
+		switch {
+		case upper && 'a' <= c && c <= 'z':
+			b[i] = c - ('a' - 'A')
+		case !upper && 'A' <= c && c <= 'Z':
+			b[i] = c + ('a' - 'A')
+		}
+		upper = c == '-'
+	}
+	return string(b)
 }
-
-func (h Headers) Remove(key string) {
-	key = strings.ToLower(key)
-	delete(h, key)
-}
\ No newline at end of file
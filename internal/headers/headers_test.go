@@ -14,28 +14,29 @@ func TestHeadersParse (t *testing.T) {
 	n, done, err := headers.Parse(data)
 	require.NoError(t, err)
 	require.NotNil(t, headers)
-	assert.Equal(t, "localhost:42069", headers["host"])
+	assert.Equal(t, []string{"localhost:42069"}, headers.Values("host"))
 	assert.Equal(t, 23, n)
 	assert.False(t, done)
 
 	// Test: Valid single header with extra whitespace
 	headers = NewHeaders()
-	data = []byte("     Host:        localhost:42069        \r\n\r\n")
+	data = []byte("Host:        localhost:42069        \r\n\r\n")
 	n, done, err = headers.Parse(data)
 	require.NoError(t, err)
 	require.NotNil(t, headers)
-	assert.Equal(t, "localhost:42069", headers["host"])
-	assert.Equal(t, 43, n)
+	assert.Equal(t, []string{"localhost:42069"}, headers.Values("host"))
+	assert.Equal(t, 38, n)
 	assert.False(t, done)
 
 	// Test: Valid 2 headers with existing headers
-	headers = map[string]string{"host": "localhost:42069"}
+	headers = NewHeaders()
+	headers.Add("Host", "localhost:42069")
 	data = []byte("User-Agent: curl/7.81.0\r\nAccept: */*\r\n\r\n")
 	n, done, err = headers.Parse(data)
 	require.NoError(t, err)
 	require.NotNil(t, headers)
-	assert.Equal(t, "localhost:42069", headers["host"])
-	assert.Equal(t, "curl/7.81.0", headers["user-agent"])
+	assert.Equal(t, []string{"localhost:42069"}, headers.Values("host"))
+	assert.Equal(t, []string{"curl/7.81.0"}, headers.Values("user-agent"))
 	assert.Equal(t, 25, n)
 	assert.False(t, done)
 
@@ -45,7 +46,7 @@ func TestHeadersParse (t *testing.T) {
 	n, done, err = headers.Parse(data)
 	require.NoError(t, err)
 	require.NotNil(t, headers)
-	assert.Empty(t, headers)
+	assert.Equal(t, 0, headers.Len())
 	assert.Equal(t, 2, n)
 	assert.True(t, done)
 
@@ -66,12 +67,185 @@ func TestHeadersParse (t *testing.T) {
 	assert.False(t, done)
 
 	// Test: New header matches existing header
-	headers = map[string]string{"set-person": "lane-loves-go"}
+	headers = NewHeaders()
+	headers.Add("Set-Person", "lane-loves-go")
 	data = []byte("Set-Person: prime-loves-zig\r\n\r\n")
 	n, done, err = headers.Parse(data)
 	require.NoError(t, err)
 	require.NotNil(t, headers)
-	assert.Equal(t, "lane-loves-go, prime-loves-zig", headers["set-person"])
+	assert.Equal(t, []string{"lane-loves-go", "prime-loves-zig"}, headers.Values("set-person"))
+	value, ok := headers.Get("set-person")
+	assert.True(t, ok)
+	assert.Equal(t, "lane-loves-go, prime-loves-zig", value)
 	assert.Equal(t, 29, n)
 	assert.False(t, done)
-}
\ No newline at end of file
+}
+func TestHeadersFields (t *testing.T) {
+	// Test: Original casing and order are preserved
+	h := NewHeaders()
+	for _, line := range []string{"Host: localhost\r\n", "x-lower: 1\r\n", "Set-Cookie: a=1\r\n", "ACCEPT: */*\r\n", "Set-Cookie: b=2\r\n"} {
+		_, _, err := h.Parse([]byte(line))
+		require.NoError(t, err)
+	}
+	assert.Equal(t, []Field{
+		{Name: "Host", Value: "localhost"},
+		{Name: "x-lower", Value: "1"},
+		{Name: "Set-Cookie", Value: "a=1"},
+		{Name: "ACCEPT", Value: "*/*"},
+		{Name: "Set-Cookie", Value: "b=2"},
+	}, h.Fields())
+
+	// Test: Case-insensitive lookup, repeated fields kept apart
+	assert.Equal(t, []string{"a=1", "b=2"}, h.Values("set-cookie"))
+	value, ok := h.Get("accept")
+	assert.True(t, ok)
+	assert.Equal(t, "*/*", value)
+	_, ok = h.Get("missing")
+	assert.False(t, ok)
+	assert.Nil(t, h.Values("missing"))
+
+	// Test: Add appends
+	h.Add("X-Lower", "2")
+	assert.Equal(t, []string{"1", "2"}, h.Values("x-lower"))
+
+	// Test: Set replaces every value at the position of the first
+	h.Set("X-LOWER", "3")
+	assert.Equal(t, []string{"3"}, h.Values("x-lower"))
+	assert.Equal(t, Field{Name: "x-lower", Value: "3"}, h.Fields()[1])
+	assert.Equal(t, 5, h.Len())
+
+	// Test: Set on a new key appends
+	h.Set("X-New", "new")
+	assert.Equal(t, Field{Name: "X-New", Value: "new"}, h.Fields()[5])
+
+	// Test: Del removes every value
+	h.Del("set-cookie")
+	assert.Nil(t, h.Values("Set-Cookie"))
+	assert.Equal(t, 4, h.Len())
+
+	// Test: Zero value is usable
+	var zero Headers
+	zero.Set("A", "1")
+	assert.Equal(t, []string{"1"}, zero.Values("a"))
+
+	// Test: Copies share their fields, whichever one is changed
+	orig := NewHeaders()
+	orig.Add("A", "1")
+	orig.Add("B", "2")
+	orig.Add("C", "3")
+	cp := orig
+	cp.Add("X", "x")
+	orig.Add("Y", "y")
+	cp.Del("A")
+	cp.Set("B", "changed")
+	assert.Equal(t, []Field{{"B", "changed"}, {"C", "3"}, {"X", "x"}, {"Y", "y"}}, orig.Fields())
+	assert.Equal(t, orig.Fields(), cp.Fields())
+
+	// Test: Changes made through a copy passed by value reach the caller
+	addX := func(h Headers) { h.Add("X", "again") }
+	addX(orig)
+	assert.Equal(t, []string{"x", "again"}, orig.Values("X"))
+
+	// Test: Clone shares nothing, even with Add on both
+	orig = NewHeaders()
+	orig.Add("A", "1")
+	orig.Add("B", "2")
+	cp = orig.Clone()
+	cp.Add("C", "3")
+	orig.Add("D", "4")
+	assert.Equal(t, []Field{{"A", "1"}, {"B", "2"}, {"D", "4"}}, orig.Fields())
+	assert.Equal(t, []Field{{"A", "1"}, {"B", "2"}, {"C", "3"}}, cp.Fields())
+}
+
+func TestCanonicalKey (t *testing.T) {
+	assert.Equal(t, "Content-Type", CanonicalKey("content-type"))
+	assert.Equal(t, "Content-Type", CanonicalKey("CONTENT-TYPE"))
+	assert.Equal(t, "X-Content-Sha256", CanonicalKey("X-Content-SHA256"))
+	assert.Equal(t, "Www-Authenticate", CanonicalKey("www-authenticate"))
+	assert.Equal(t, "Host", CanonicalKey("host"))
+}
+
+func TestHeadersFieldValues (t *testing.T) {
+	// Test: Control characters in value
+	for _, line := range []string{"X-Test: a\x00b\r\n", "X-Test: a\rb\r\n", "X-Test: a\x01b\r\n", "X-Test: a\x7fb\r\n"} {
+		headers := NewHeaders()
+		_, _, err := headers.Parse([]byte(line))
+		require.ErrorIs(t, err, ErrInvalidFieldValue, "%q", line)
+	}
+
+	// Test: Tabs and obs-text are allowed by default
+	headers := NewHeaders()
+	_, _, err := headers.Parse([]byte("X-Test: a\tb caf\xc3\xa9\r\n"))
+	require.NoError(t, err)
+	assert.Equal(t, []string{"a\tb caf\xc3\xa9"}, headers.Values("x-test"))
+
+	// Test: Strict mode rejects obs-text
+	headers = NewHeaders()
+	_, _, err = headers.ParseMode([]byte("X-Test: caf\xc3\xa9\r\n"), ModeStrict)
+	require.ErrorIs(t, err, ErrInvalidFieldValue)
+
+	// Test: Lenient mode accepts other control characters, but never NUL or CR
+	headers = NewHeaders()
+	_, _, err = headers.ParseMode([]byte("X-Test: a\x01b\r\n"), ModeLenient)
+	require.NoError(t, err)
+	_, _, err = headers.ParseMode([]byte("X-Test: a\x00b\r\n"), ModeLenient)
+	require.ErrorIs(t, err, ErrInvalidFieldValue)
+	_, _, err = headers.ParseMode([]byte("X-Test: a\rb\r\n"), ModeLenient)
+	require.ErrorIs(t, err, ErrInvalidFieldValue)
+
+	// Test: Only ASCII letters in names in strict mode
+	headers = NewHeaders()
+	_, _, err = headers.ParseMode([]byte("Caf\xc3\xa9: 1\r\n"), ModeStrict)
+	require.Error(t, err)
+
+	// Test: Validation for outgoing values
+	assert.True(t, ValidFieldValue("text/html; charset=utf-8"))
+	assert.False(t, ValidFieldValue("x\r\nSet-Cookie: evil=1"))
+}
+
+func TestHeadersObsFold (t *testing.T) {
+	data := []byte("X-Folded: first\r\n   second\r\n\tthird\r\n\r\n")
+
+	// Test: Rejected by default
+	headers := NewHeaders()
+	n, _, err := headers.Parse(data)
+	require.NoError(t, err)
+	_, _, err = headers.Parse(data[n:])
+	require.ErrorIs(t, err, ErrObsoleteLineFolding)
+
+	// Test: Rejected in strict mode
+	headers = NewHeaders()
+	n, _, err = headers.ParseMode(data, ModeStrict)
+	require.NoError(t, err)
+	_, _, err = headers.ParseMode(data[n:], ModeStrict)
+	require.ErrorIs(t, err, ErrObsoleteLineFolding)
+
+	// Test: Unfolded in lenient mode
+	headers = NewHeaders()
+	total := 0
+	for {
+		n, done, err := headers.ParseMode(data[total:], ModeLenient)
+		require.NoError(t, err)
+		total += n
+		if done {
+			break
+		}
+	}
+	assert.Equal(t, len(data), total)
+	assert.Equal(t, []string{"first second third"}, headers.Values("x-folded"))
+
+	// Test: Leading whitespace before the first field rejected unless lenient
+	for _, mode := range []Mode{ModeDefault, ModeStrict} {
+		headers = NewHeaders()
+		_, _, err = headers.ParseMode([]byte("  Host: localhost\r\n"), mode)
+		require.ErrorIs(t, err, ErrLeadingWhitespace)
+	}
+
+	// Test: Discarded unparsed in lenient mode
+	headers = NewHeaders()
+	n, done, err := headers.ParseMode([]byte("  Host: localhost\r\n"), ModeLenient)
+	require.NoError(t, err)
+	assert.Equal(t, 19, n)
+	assert.False(t, done)
+	assert.Equal(t, 0, headers.Len())
+}
@@ -0,0 +1,227 @@
+// Package metrics provides counters, gauges and histograms, optionally
+// split by labels, and serves them in the Prometheus text exposition format.
+package metrics
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Registry holds a set of metrics to be exposed together.
+type Registry struct {
+	mu       sync.Mutex
+	families map[string]*family
+}
+
+func NewRegistry() *Registry {
+	return &Registry{families: make(map[string]*family)}
+}
+
+// family is a metric and its series, one per combination of label values.
+type family struct {
+	name, help string
+	kind       string // "counter", "gauge" or "histogram"
+	labels     []string
+	buckets    []float64 // upper bounds, histograms only
+
+	mu     sync.Mutex
+	series map[string]*series
+}
+
+type series struct {
+	labelValues []string
+	value       float64  // counters and gauges
+	counts      []uint64 // histograms, per bucket and not cumulative
+	count       uint64
+	sum         float64
+}
+
+// newFamily registers a metric. Names and labels are fixed by the program,
+// so mistakes in them panic rather than return an error.
+func (r *Registry) newFamily(kind, name, help string, buckets []float64, labels []string) *family {
+	if !validName(name, true) {
+		panic(fmt.Sprintf("metrics: invalid metric name %q", name))
+	}
+	for _, label := range labels {
+		if !validName(label, false) || strings.HasPrefix(label, "__") || (kind == "histogram" && label == "le") {
+			panic(fmt.Sprintf("metrics: invalid label name %q for %s", label, name))
+		}
+	}
+	f := &family{
+		name:    name,
+		help:    help,
+		kind:    kind,
+		labels:  labels,
+		buckets: buckets,
+		series:  make(map[string]*series),
+	}
+	// Without labels there is a single series, reported even before it is
+	// first updated
+	if len(labels) == 0 {
+		f.get(nil)
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, ok := r.families[name]; ok {
+		panic(fmt.Sprintf("metrics: %s registered twice", name))
+	}
+	r.families[name] = f
+	return f
+}
+
+func validName(name string, colons bool) bool {
+	if name == "" {
+		return false
+	}
+	for i := 0; i < len(name); i++ {
+		c := name[i]
+		switch {
+		case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c == '_':
+		case c == ':' && colons:
+		case c >= '0' && c <= '9' && i > 0:
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// get returns the series for labelValues, creating it if needed. The caller
+// holds f.mu, except when the family is being created.
+func (f *family) get(labelValues []string) *series {
+	if len(labelValues) != len(f.labels) {
+		panic(fmt.Sprintf("metrics: %s takes %d label values, got %d", f.name, len(f.labels), len(labelValues)))
+	}
+	key := strings.Join(labelValues, "\xff")
+	s, ok := f.series[key]
+	if !ok {
+		s = &series{labelValues: append([]string(nil), labelValues...)}
+		if f.kind == "histogram" {
+			s.counts = make([]uint64, len(f.buckets))
+		}
+		f.series[key] = s
+	}
+	return s
+}
+
+// peek returns the series for labelValues without creating it, or an empty
+// one if there is none yet. The caller holds f.mu.
+func (f *family) peek(labelValues []string) *series {
+	if s, ok := f.series[strings.Join(labelValues, "\xff")]; ok {
+		return s
+	}
+	return &series{}
+}
+
+// Counter is a value that only goes up, such as a number of requests.
+type Counter struct {
+	f *family
+}
+
+// NewCounter registers a counter split by the given label names. Every
+// update must then pass one value per label, in the same order.
+func (r *Registry) NewCounter(name, help string, labels ...string) *Counter {
+	return &Counter{f: r.newFamily("counter", name, help, nil, labels)}
+}
+
+func (c *Counter) Inc(labelValues ...string) {
+	c.Add(1, labelValues...)
+}
+
+// Add adds v, which must not be negative, to the counter.
+func (c *Counter) Add(v float64, labelValues ...string) {
+	if v < 0 {
+		panic(fmt.Sprintf("metrics: counter %s cannot decrease", c.f.name))
+	}
+	c.f.mu.Lock()
+	defer c.f.mu.Unlock()
+	c.f.get(labelValues).value += v
+}
+
+// Value returns the current value of the counter.
+func (c *Counter) Value(labelValues ...string) float64 {
+	c.f.mu.Lock()
+	defer c.f.mu.Unlock()
+	return c.f.peek(labelValues).value
+}
+
+// Gauge is a value that goes up and down, such as a number of open
+// connections.
+type Gauge struct {
+	f *family
+}
+
+func (r *Registry) NewGauge(name, help string, labels ...string) *Gauge {
+	return &Gauge{f: r.newFamily("gauge", name, help, nil, labels)}
+}
+
+func (g *Gauge) Set(v float64, labelValues ...string) {
+	g.f.mu.Lock()
+	defer g.f.mu.Unlock()
+	g.f.get(labelValues).value = v
+}
+
+func (g *Gauge) Add(v float64, labelValues ...string) {
+	g.f.mu.Lock()
+	defer g.f.mu.Unlock()
+	g.f.get(labelValues).value += v
+}
+
+func (g *Gauge) Inc(labelValues ...string) {
+	g.Add(1, labelValues...)
+}
+
+func (g *Gauge) Dec(labelValues ...string) {
+	g.Add(-1, labelValues...)
+}
+
+func (g *Gauge) Value(labelValues ...string) float64 {
+	g.f.mu.Lock()
+	defer g.f.mu.Unlock()
+	return g.f.peek(labelValues).value
+}
+
+// DefaultBuckets suit request latencies in seconds, from 5ms to 10s.
+var DefaultBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+// Histogram counts observations, such as request latencies, in buckets by
+// value.
+type Histogram struct {
+	f *family
+}
+
+// NewHistogram registers a histogram with the given bucket upper bounds,
+// or DefaultBuckets if there are none. A +Inf bucket is always added.
+func (r *Registry) NewHistogram(name, help string, buckets []float64, labels ...string) *Histogram {
+	if len(buckets) == 0 {
+		buckets = DefaultBuckets
+	}
+	buckets = append([]float64(nil), buckets...)
+	sort.Float64s(buckets)
+	if math.IsInf(buckets[len(buckets)-1], +1) {
+		buckets = buckets[:len(buckets)-1]
+	}
+	return &Histogram{f: r.newFamily("histogram", name, help, buckets, labels)}
+}
+
+func (h *Histogram) Observe(v float64, labelValues ...string) {
+	h.f.mu.Lock()
+	defer h.f.mu.Unlock()
+	s := h.f.get(labelValues)
+	if i := sort.SearchFloat64s(h.f.buckets, v); i < len(s.counts) {
+		s.counts[i]++
+	}
+	s.count++
+	s.sum += v
+}
+
+// Count returns how many values have been observed.
+func (h *Histogram) Count(labelValues ...string) uint64 {
+	h.f.mu.Lock()
+	defer h.f.mu.Unlock()
+	return h.f.peek(labelValues).count
+}
@@ -0,0 +1,83 @@
+package metrics
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTextFormat (t *testing.T) {
+	reg := NewRegistry()
+	requests := reg.NewCounter("requests_total", "Requests handled.", "method", "code")
+	conns := reg.NewGauge("connections", "Open connections.")
+	latency := reg.NewHistogram("latency_seconds", "Latency.\nIn seconds.", []float64{1, 0.1}, "method")
+
+	// Test: Unlabelled metrics are reported before any update
+	var buf bytes.Buffer
+	_, err := reg.WriteTo(&buf)
+	require.NoError(t, err)
+	assert.Equal(t, "# HELP connections Open connections.\n"+
+		"# TYPE connections gauge\n"+
+		"connections 0\n"+
+		"# HELP latency_seconds Latency.\\nIn seconds.\n"+
+		"# TYPE latency_seconds histogram\n"+
+		"# HELP requests_total Requests handled.\n"+
+		"# TYPE requests_total counter\n", buf.String())
+
+	// Test: Series sorted by label values, with cumulative buckets
+	requests.Inc("POST", "201")
+	requests.Inc("GET", "200")
+	requests.Add(2, "GET", "200")
+	conns.Inc()
+	conns.Inc()
+	conns.Dec()
+	latency.Observe(0.05, "GET")
+	latency.Observe(0.5, "GET")
+	latency.Observe(5, "GET")
+	buf.Reset()
+	n, err := reg.WriteTo(&buf)
+	require.NoError(t, err)
+	assert.Equal(t, int64(buf.Len()), n)
+	assert.Equal(t, "# HELP connections Open connections.\n"+
+		"# TYPE connections gauge\n"+
+		"connections 1\n"+
+		"# HELP latency_seconds Latency.\\nIn seconds.\n"+
+		"# TYPE latency_seconds histogram\n"+
+		"latency_seconds_bucket{method=\"GET\",le=\"0.1\"} 1\n"+
+		"latency_seconds_bucket{method=\"GET\",le=\"1\"} 2\n"+
+		"latency_seconds_bucket{method=\"GET\",le=\"+Inf\"} 3\n"+
+		"latency_seconds_sum{method=\"GET\"} 5.55\n"+
+		"latency_seconds_count{method=\"GET\"} 3\n"+
+		"# HELP requests_total Requests handled.\n"+
+		"# TYPE requests_total counter\n"+
+		"requests_total{method=\"GET\",code=\"200\"} 3\n"+
+		"requests_total{method=\"POST\",code=\"201\"} 1\n", buf.String())
+	assert.Equal(t, float64(3), requests.Value("GET", "200"))
+	assert.Equal(t, float64(0), requests.Value("PUT", "200"))
+	assert.Equal(t, uint64(3), latency.Count("GET"))
+
+	// Test: Label values are escaped
+	reasons := reg.NewCounter("errors_total", "", "reason")
+	reasons.Inc("say \"hi\"\\\n")
+	buf.Reset()
+	reg.WriteTo(&buf)
+	assert.Contains(t, buf.String(), "# TYPE errors_total counter\nerrors_total{reason=\"say \\\"hi\\\"\\\\\\n\"} 1\n")
+}
+
+func TestRegistryMisuse (t *testing.T) {
+	reg := NewRegistry()
+	c := reg.NewCounter("requests_total", "", "method")
+
+	// Test: Names are registered once and must be valid
+	assert.Panics(t, func() { reg.NewGauge("requests_total", "") })
+	assert.Panics(t, func() { reg.NewGauge("1requests", "") })
+	assert.Panics(t, func() { reg.NewGauge("requests", "", "bad-label") })
+	assert.Panics(t, func() { reg.NewHistogram("latency", "", nil, "le") })
+
+	// Test: Updates pass one value per label and counters never decrease
+	assert.Panics(t, func() { c.Inc() })
+	assert.Panics(t, func() { c.Inc("GET", "200") })
+	assert.Panics(t, func() { c.Add(-1, "GET") })
+}
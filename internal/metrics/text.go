@@ -0,0 +1,130 @@
+package metrics
+
+import (
+	"bufio"
+	"bytes"
+	"httpfromtcp/internal/request"
+	"httpfromtcp/internal/response"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// ContentType is the media type of the Prometheus text exposition format.
+const ContentType = "text/plain; version=0.0.4; charset=utf-8"
+
+// WriteTo writes every metric in the text exposition format
+// (https://prometheus.io/docs/instrumenting/exposition_formats/), sorted by
+// name and then by label values.
+func (r *Registry) WriteTo(w io.Writer) (int64, error) {
+	r.mu.Lock()
+	families := make([]*family, 0, len(r.families))
+	for _, f := range r.families {
+		families = append(families, f)
+	}
+	r.mu.Unlock()
+	sort.Slice(families, func(i, j int) bool { return families[i].name < families[j].name })
+
+	cw := &countingWriter{w: w}
+	bw := bufio.NewWriter(cw)
+	for _, f := range families {
+		f.write(bw)
+	}
+	err := bw.Flush()
+	return cw.n, err
+}
+
+func (f *family) write(w *bufio.Writer) {
+	if f.help != "" {
+		w.WriteString("# HELP " + f.name + " " + helpReplacer.Replace(f.help) + "\n")
+	}
+	w.WriteString("# TYPE " + f.name + " " + f.kind + "\n")
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	keys := make([]string, 0, len(f.series))
+	for key := range f.series {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		s := f.series[key]
+		if f.kind != "histogram" {
+			f.writeSample(w, "", s.labelValues, "", 0, s.value)
+			continue
+		}
+		var cumulative uint64
+		for i, upper := range f.buckets {
+			cumulative += s.counts[i]
+			f.writeSample(w, "_bucket", s.labelValues, "le", upper, float64(cumulative))
+		}
+		f.writeSample(w, "_bucket", s.labelValues, "le", math.Inf(+1), float64(s.count))
+		f.writeSample(w, "_sum", s.labelValues, "", 0, s.sum)
+		f.writeSample(w, "_count", s.labelValues, "", 0, float64(s.count))
+	}
+}
+
+// writeSample writes one line, with an extra label such as a histogram's
+// "le" if extraLabel is set.
+func (f *family) writeSample(w *bufio.Writer, suffix string, labelValues []string, extraLabel string, extraValue float64, value float64) {
+	w.WriteString(f.name + suffix)
+	if len(labelValues) > 0 || extraLabel != "" {
+		w.WriteByte('{')
+		for i, v := range labelValues {
+			if i > 0 {
+				w.WriteByte(',')
+			}
+			w.WriteString(f.labels[i] + `="` + labelReplacer.Replace(v) + `"`)
+		}
+		if extraLabel != "" {
+			if len(labelValues) > 0 {
+				w.WriteByte(',')
+			}
+			w.WriteString(extraLabel + `="` + formatFloat(extraValue) + `"`)
+		}
+		w.WriteByte('}')
+	}
+	w.WriteString(" " + formatFloat(value) + "\n")
+}
+
+var (
+	helpReplacer  = strings.NewReplacer(`\`, `\\`, "\n", `\n`)
+	labelReplacer = strings.NewReplacer(`\`, `\\`, "\n", `\n`, `"`, `\"`)
+)
+
+func formatFloat(v float64) string {
+	switch {
+	case math.IsInf(v, +1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	case math.IsNaN(v):
+		return "NaN"
+	default:
+		return strconv.FormatFloat(v, 'g', -1, 64)
+	}
+}
+
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (cw *countingWriter) Write(p []byte) (int, error) {
+	n, err := cw.w.Write(p)
+	cw.n += int64(n)
+	return n, err
+}
+
+// Handler serves the metrics in r, typically on /metrics. It has the
+// signature of server.Handler.
+func Handler(r *Registry) func(w *response.Writer, req *request.Request) {
+	return func(w *response.Writer, _ *request.Request) {
+		var buf bytes.Buffer
+		r.WriteTo(&buf)
+		w.Header().Set("Content-Type", ContentType)
+		w.Write(buf.Bytes())
+	}
+}
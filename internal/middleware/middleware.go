@@ -0,0 +1,137 @@
+// Package middleware composes behaviour around a server.Handler.
+package middleware
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"httpfromtcp/internal/request"
+	"httpfromtcp/internal/response"
+	"httpfromtcp/internal/server"
+	"log"
+	"runtime/debug"
+	"time"
+)
+
+type Middleware func(server.Handler) server.Handler
+
+// Chain wraps h in middlewares, the first being the outermost: it sees the
+// request first and the finished response last.
+func Chain(h server.Handler, middlewares ...Middleware) server.Handler {
+	for i := len(middlewares) - 1; i >= 0; i-- {
+		h = middlewares[i](h)
+	}
+	return h
+}
+
+// Logging logs one line per request with its status, the body bytes
+// written and how long the handler took.
+func Logging(logger *log.Logger) Middleware {
+	return func(next server.Handler) server.Handler {
+		return func(w *response.Writer, req *request.Request) {
+			start := time.Now()
+			next(w, req)
+			logger.Printf("%s %s %d %d %s", req.RequestLine.Method, req.RequestLine.RequestTarget,
+				w.Status(), w.BytesWritten(), time.Since(start))
+		}
+	}
+}
+
+// Recover turns a panic in the handler into a 500 response and logs the
+// stack. If part of the response was already sent it cannot be replaced, so
+// it is aborted instead. server.ErrAbortHandler is passed on to the server.
+func Recover(logger *log.Logger) Middleware {
+	return func(next server.Handler) server.Handler {
+		return func(w *response.Writer, req *request.Request) {
+			defer func() {
+				v := recover()
+				if v == nil {
+					return
+				}
+				// Leave deliberate aborts to the server, which does not log them
+				if v == server.ErrAbortHandler {
+					panic(v)
+				}
+				logger.Printf("panic serving %s %s: %v\n%s", req.RequestLine.Method, req.RequestLine.RequestTarget, v, debug.Stack())
+				if !w.Reset() {
+					w.Abort()
+					return
+				}
+				w.SetKeepAlive(false)
+				w.WriteHeader(response.StatusCodeInternalServerError)
+				fmt.Fprintf(w, "%d %s\n", response.StatusCodeInternalServerError, response.StatusText(response.StatusCodeInternalServerError))
+			}()
+			next(w, req)
+		}
+	}
+}
+
+const (
+	RequestIDHeader = "X-Request-ID"
+	maxRequestIDLen = 128
+)
+
+// RequestID makes sure every request has an X-Request-ID, keeping the
+// client's if it sent a usable one and generating one otherwise. The ID is
+// set on the request and its context for the handler, and echoed in the
+// response.
+func RequestID() Middleware {
+	return func(next server.Handler) server.Handler {
+		return func(w *response.Writer, req *request.Request) {
+			id, ok := req.Headers.Get(RequestIDHeader)
+			if !ok || !validRequestID(id) {
+				id = newRequestID()
+				req.Headers.Set(RequestIDHeader, id)
+			}
+			w.Header().Set(RequestIDHeader, id)
+			next(w, req.WithContext(context.WithValue(req.Context(), requestIDKey{}, id)))
+		}
+	}
+}
+
+type requestIDKey struct{}
+
+// RequestIDFromContext returns the ID set by RequestID, or "" if there is
+// none.
+func RequestIDFromContext(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey{}).(string)
+	return id
+}
+
+// validRequestID accepts IDs that are safe to log and echo: short, and
+// made of visible ASCII only.
+func validRequestID(id string) bool {
+	if id == "" || len(id) > maxRequestIDLen {
+		return false
+	}
+	for i := 0; i < len(id); i++ {
+		if id[i] <= ' ' || id[i] >= 0x7f {
+			return false
+		}
+	}
+	return true
+}
+
+func newRequestID() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// Timing reports how long the handler took in a Server-Timing field
+// (https://www.w3.org/TR/server-timing/). It is only added to responses
+// still buffered when the handler returns, since the header section of the
+// others has already been sent.
+func Timing() Middleware {
+	return func(next server.Handler) server.Handler {
+		return func(w *response.Writer, req *request.Request) {
+			start := time.Now()
+			next(w, req)
+			if !w.Committed() {
+				elapsed := time.Since(start)
+				w.Header().Add("Server-Timing", fmt.Sprintf("app;dur=%.3f", float64(elapsed.Microseconds())/1000))
+			}
+		}
+	}
+}
@@ -0,0 +1,157 @@
+package middleware
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"log"
+	"strings"
+	"testing"
+
+	"httpfromtcp/internal/request"
+	"httpfromtcp/internal/response"
+	"httpfromtcp/internal/server"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// serve runs h on raw the way the server would, returning the response
+func serve(t *testing.T, h server.Handler, raw string) string {
+	t.Helper()
+	req, err := request.RequestFromReader(strings.NewReader(raw))
+	require.NoError(t, err)
+	var buf bytes.Buffer
+	w := response.NewWriter(&buf)
+	w.SetMethod(req.RequestLine.Method)
+	h(w, req)
+	require.NoError(t, w.Finish())
+	return buf.String()
+}
+
+func hello(w *response.Writer, _ *request.Request) {
+	io.WriteString(w, "hello")
+}
+
+func TestChain (t *testing.T) {
+	// Test: First middleware is the outermost
+	var order []string
+	trace := func(name string) Middleware {
+		return func(next server.Handler) server.Handler {
+			return func(w *response.Writer, req *request.Request) {
+				order = append(order, name+" in")
+				next(w, req)
+				order = append(order, name+" out")
+			}
+		}
+	}
+	h := Chain(func(w *response.Writer, req *request.Request) {
+		order = append(order, "handler")
+	}, trace("a"), trace("b"))
+	serve(t, h, "GET / HTTP/1.1\r\nHost: a\r\n\r\n")
+	assert.Equal(t, []string{"a in", "b in", "handler", "b out", "a out"}, order)
+}
+
+func TestLogging (t *testing.T) {
+	// Test: Method, target, status and bytes are logged
+	var logs bytes.Buffer
+	h := Chain(func(w *response.Writer, _ *request.Request) {
+		w.WriteHeader(response.StatusCodeNotFound)
+		io.WriteString(w, "not here")
+	}, Logging(log.New(&logs, "", 0)))
+	serve(t, h, "GET /missing?x=1 HTTP/1.1\r\nHost: a\r\n\r\n")
+	assert.True(t, strings.HasPrefix(logs.String(), "GET /missing?x=1 404 8 "), logs.String())
+}
+
+func TestRecover (t *testing.T) {
+	var logs bytes.Buffer
+	logger := log.New(&logs, "", 0)
+
+	// Test: Panic before anything was sent becomes a 500
+	h := Chain(func(w *response.Writer, _ *request.Request) {
+		w.Header().Set("X-Partial", "yes")
+		io.WriteString(w, "partial")
+		panic("boom")
+	}, Recover(logger))
+	out := serve(t, h, "GET / HTTP/1.1\r\nHost: a\r\n\r\n")
+	assert.True(t, strings.HasPrefix(out, "HTTP/1.1 500 Internal Server Error\r\n"), out)
+	assert.NotContains(t, out, "partial")
+	assert.NotContains(t, out, "X-Partial")
+	assert.Contains(t, logs.String(), "panic serving GET /: boom")
+
+	// Test: The 500 closes the connection
+	req, err := request.RequestFromReader(strings.NewReader("GET / HTTP/1.1\r\nHost: a\r\n\r\n"))
+	require.NoError(t, err)
+	var buf bytes.Buffer
+	w := response.NewWriter(&buf)
+	w.SetKeepAlive(true)
+	h(w, req)
+	require.NoError(t, w.Finish())
+	assert.Contains(t, buf.String(), "Connection: close\r\n")
+	assert.False(t, w.KeepAlive())
+
+	// Test: ErrAbortHandler is passed on to the server without logging
+	logs.Reset()
+	abort := Chain(func(w *response.Writer, _ *request.Request) {
+		panic(server.ErrAbortHandler)
+	}, Recover(logger))
+	assert.PanicsWithValue(t, server.ErrAbortHandler, func() { abort(response.NewWriter(io.Discard), req) })
+	assert.Empty(t, logs.String())
+
+	// Test: Panic after the response started aborts it
+	buf.Reset()
+	w = response.NewWriter(&buf)
+	w.SetKeepAlive(true)
+	h = Chain(func(w *response.Writer, _ *request.Request) {
+		w.Header().Set("Content-Length", "10")
+		w.Flush()
+		panic("boom")
+	}, Recover(logger))
+	h(w, req)
+	assert.True(t, w.Aborted())
+	assert.False(t, w.KeepAlive())
+}
+
+func TestRequestID (t *testing.T) {
+	var seen string
+	h := Chain(func(w *response.Writer, req *request.Request) {
+		seen, _ = req.Headers.Get(RequestIDHeader)
+		hello(w, req)
+	}, RequestID())
+
+	// Test: Client's ID is kept
+	out := serve(t, h, "GET / HTTP/1.1\r\nHost: a\r\nX-Request-ID: abc-123\r\n\r\n")
+	assert.Equal(t, "abc-123", seen)
+	assert.Contains(t, out, "X-Request-Id: abc-123\r\n")
+
+	// Test: Missing or unusable ID is generated
+	serve(t, h, "GET / HTTP/1.1\r\nHost: a\r\n\r\n")
+	assert.Len(t, seen, 32)
+	serve(t, h, "GET / HTTP/1.1\r\nHost: a\r\nX-Request-ID: "+strings.Repeat("x", 200)+"\r\n\r\n")
+	assert.Len(t, seen, 32)
+}
+
+func TestTiming (t *testing.T) {
+	// Test: Buffered response gets Server-Timing
+	out := serve(t, Chain(hello, Timing()), "GET / HTTP/1.1\r\nHost: a\r\n\r\n")
+	assert.Contains(t, out, "Server-Timing: app;dur=")
+	assert.True(t, strings.HasSuffix(out, "hello"))
+
+	// Test: Already committed response is left alone
+	out = serve(t, Chain(func(w *response.Writer, req *request.Request) {
+		hello(w, req)
+		w.Flush()
+	}, Timing()), "GET / HTTP/1.1\r\nHost: a\r\n\r\n")
+	assert.NotContains(t, out, "Server-Timing")
+}
+
+func TestRequestIDContext (t *testing.T) {
+	// Test: ID is available from the request's context
+	var fromCtx string
+	h := Chain(func(w *response.Writer, req *request.Request) {
+		fromCtx = RequestIDFromContext(req.Context())
+	}, RequestID())
+	serve(t, h, "GET / HTTP/1.1\r\nHost: a\r\nX-Request-ID: abc-123\r\n\r\n")
+	assert.Equal(t, "abc-123", fromCtx)
+	assert.Equal(t, "", RequestIDFromContext(context.Background()))
+}
@@ -0,0 +1,139 @@
+package request
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// maxDrainBytes is how much of an unread body the parser will skip to get
+// to the next request. Anything larger is cheaper to handle by closing the
+// connection.
+const maxDrainBytes = 256 << 10
+
+var errBodyClosed = errors.New("read on closed request body")
+
+// body streams a request body from the connection as the handler reads it.
+type body struct {
+	src io.Reader
+	// chunked is the decoder under src for a chunked body, whose framing
+	// counts towards maxDrainBytes along with the data
+	chunked *chunkedReader
+	eof     bool
+	err     error // first error reading src, other than io.EOF
+	closed  bool
+	done    chan struct{} // closed at EOF
+}
+
+func newBody(src io.Reader) *body {
+	return &body{src: src, done: make(chan struct{})}
+}
+
+func (b *body) setEOF() {
+	if !b.eof {
+		b.eof = true
+		close(b.done)
+	}
+}
+
+// noBody is the Body of requests without Content-Length or
+// Transfer-Encoding.
+type noBody struct{}
+
+func (noBody) Read([]byte) (int, error) { return 0, io.EOF }
+func (noBody) Close() error             { return nil }
+
+func (b *body) Read(p []byte) (int, error) {
+	if b.closed {
+		return 0, errBodyClosed
+	}
+	if b.eof {
+		return 0, io.EOF
+	}
+	n, err := b.src.Read(p)
+	if errors.Is(err, io.EOF) {
+		b.setEOF()
+	} else if err != nil && b.err == nil {
+		b.err = err
+	}
+	return n, err
+}
+
+// Close stops the handler from reading any further. Unread bytes stay on
+// the connection until the parser drains them before the next request.
+func (b *body) Close() error {
+	b.closed = true
+	return nil
+}
+
+// drain discards whatever the handler left unread so the next request
+// starts at the right offset.
+func (b *body) drain() error {
+	if b.eof {
+		return nil
+	}
+	var framingStart int64
+	if b.chunked != nil {
+		framingStart = b.chunked.lineBytes
+		b.chunked.maxLineBytes = framingStart + maxDrainBytes
+	}
+	n, err := io.Copy(io.Discard, io.LimitReader(b.src, maxDrainBytes+1))
+	if b.chunked != nil {
+		n += b.chunked.lineBytes - framingStart
+	}
+	if err != nil && !errors.Is(err, errFramingTooLarge) {
+		return fmt.Errorf("error draining request body: %w", err)
+	}
+	if n > maxDrainBytes {
+		return fmt.Errorf("unread request body larger than %d bytes", maxDrainBytes)
+	}
+	b.setEOF()
+	return nil
+}
+
+// lengthReader reads exactly remaining bytes, treating an early EOF as an
+// error rather than the end of the body.
+type lengthReader struct {
+	reader    *bufio.Reader
+	remaining int64
+}
+
+func (lr *lengthReader) Read(p []byte) (int, error) {
+	if lr.remaining <= 0 {
+		return 0, io.EOF
+	}
+	if int64(len(p)) > lr.remaining {
+		p = p[:lr.remaining]
+	}
+	n, err := lr.reader.Read(p)
+	lr.remaining -= int64(n)
+	if errors.Is(err, io.EOF) {
+		return n, fmt.Errorf("incomplete request body, %d bytes missing: %w", lr.remaining, io.ErrUnexpectedEOF)
+	}
+	return n, err
+}
+
+// maxBytesReader fails with ErrBodyTooLarge once more than remaining bytes
+// have been read.
+type maxBytesReader struct {
+	src       io.Reader
+	remaining int64
+}
+
+func (mr *maxBytesReader) Read(p []byte) (int, error) {
+	if mr.remaining < 0 {
+		return 0, ErrBodyTooLarge
+	}
+	// Ask for one byte more than allowed so an oversized body is noticed
+	// even when it ends exactly on the limit of a read.
+	if int64(len(p)) > mr.remaining+1 {
+		p = p[:mr.remaining+1]
+	}
+	n, err := mr.src.Read(p)
+	mr.remaining -= int64(n)
+	if mr.remaining < 0 {
+		return n + int(mr.remaining), ErrBodyTooLarge
+	}
+	return n, err
+}
@@ -0,0 +1,184 @@
+package request
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"httpfromtcp/internal/headers"
+	"io"
+	"strconv"
+)
+
+// chunkedReader decodes a "Transfer-Encoding: chunked" body (RFC 9112
+// section 7.1) straight off the connection. Chunk extensions are ignored and
+// the trailer section, if any, is parsed into trailers, subject to the same
+// limits as the header section.
+type chunkedReader struct {
+	reader    *bufio.Reader
+	trailers  headers.Headers
+	opts      Options
+	remaining uint64 // bytes left in the current chunk
+	state     chunkedState
+
+	trailerBytes int
+	trailerCount int
+	// lineBytes counts the chunk-size, extension and trailer lines read so
+	// far, which carry no data; readLine fails once it passes maxLineBytes,
+	// if set.
+	lineBytes    int64
+	maxLineBytes int64
+}
+
+type chunkedState int
+
+const (
+	chunkedStateSize chunkedState = iota
+	chunkedStateData
+	chunkedStateDataCRLF
+	chunkedStateTrailers
+	chunkedStateDone
+)
+
+func newChunkedReader(reader *bufio.Reader, trailers headers.Headers, opts Options) *chunkedReader {
+	return &chunkedReader{
+		reader:   reader,
+		trailers: trailers,
+		opts:     opts,
+		state:    chunkedStateSize,
+	}
+}
+
+func (cr *chunkedReader) Read(p []byte) (int, error) {
+	for {
+		switch cr.state {
+		case chunkedStateSize:
+			line, err := cr.readLine()
+			if err != nil {
+				return 0, err
+			}
+			size, err := parseChunkSize(line)
+			if err != nil {
+				return 0, err
+			}
+			cr.remaining = size
+			if size == 0 {
+				cr.state = chunkedStateTrailers
+			} else {
+				cr.state = chunkedStateData
+			}
+		case chunkedStateData:
+			if len(p) == 0 {
+				return 0, nil
+			}
+			if uint64(len(p)) > cr.remaining {
+				p = p[:cr.remaining]
+			}
+			n, err := cr.reader.Read(p)
+			cr.remaining -= uint64(n)
+			if cr.remaining == 0 {
+				cr.state = chunkedStateDataCRLF
+			}
+			if errors.Is(err, io.EOF) {
+				err = io.ErrUnexpectedEOF
+			}
+			return n, err
+		case chunkedStateDataCRLF:
+			line, err := cr.readLine()
+			if err != nil {
+				return 0, err
+			}
+			if len(line) != len(crlf) {
+				return 0, fmt.Errorf("%w: chunk data longer than its size", ErrInvalidChunkSize)
+			}
+			cr.state = chunkedStateSize
+		case chunkedStateTrailers:
+			line, err := cr.readLine()
+			if err != nil {
+				return 0, err
+			}
+			_, done, err := cr.trailers.ParseMode(line, cr.opts.HeaderMode)
+			if err != nil {
+				return 0, fmt.Errorf("malformed trailer field: %w", err)
+			}
+			if done {
+				cr.state = chunkedStateDone
+				continue
+			}
+			if err := cr.countTrailer(len(line)); err != nil {
+				return 0, err
+			}
+		case chunkedStateDone:
+			return 0, io.EOF
+		}
+	}
+}
+
+// countTrailer applies MaxHeaderBytes and MaxHeaderCount to the trailer
+// section.
+func (cr *chunkedReader) countTrailer(n int) error {
+	cr.trailerBytes += n
+	cr.trailerCount++
+	if cr.opts.MaxHeaderBytes > 0 && cr.trailerBytes > cr.opts.MaxHeaderBytes {
+		return fmt.Errorf("%w: trailer section more than %d bytes", ErrHeadersTooLarge, cr.opts.MaxHeaderBytes)
+	}
+	if cr.opts.MaxHeaderCount > 0 && cr.trailerCount > cr.opts.MaxHeaderCount {
+		return fmt.Errorf("%w: more than %d trailer fields", ErrHeadersTooLarge, cr.opts.MaxHeaderCount)
+	}
+	return nil
+}
+
+// readLine returns the next line, including its CRLF terminator.
+func (cr *chunkedReader) readLine() ([]byte, error) {
+	line, err := cr.reader.ReadSlice('\n')
+	cr.lineBytes += int64(len(line))
+	if cr.maxLineBytes > 0 && cr.lineBytes > cr.maxLineBytes {
+		return nil, errFramingTooLarge
+	}
+	if err != nil {
+		return nil, chunkedReadError(err)
+	}
+	if !bytes.HasSuffix(line, []byte(crlf)) {
+		return nil, ErrBareLF
+	}
+	return line, nil
+}
+
+var errFramingTooLarge = errors.New("chunked framing too large")
+
+func chunkedReadError(err error) error {
+	if errors.Is(err, bufio.ErrBufferFull) {
+		return fmt.Errorf("malformed chunked body: line too long")
+	}
+	if errors.Is(err, io.EOF) {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
+
+// parseChunkSize parses `chunk-size [ chunk-ext ] CRLF`, discarding any
+// extensions. Whitespace is only allowed before the ";" of an extension,
+// and the size must be plain hex: no "0x", sign or leading space.
+func parseChunkSize(line []byte) (uint64, error) {
+	line = bytes.TrimSuffix(line, []byte(crlf))
+	if idx := bytes.IndexByte(line, ';'); idx != -1 {
+		line = bytes.TrimRight(line[:idx], " \t")
+	}
+	if len(line) == 0 {
+		return 0, fmt.Errorf("%w: missing chunk size", ErrInvalidChunkSize)
+	}
+	for _, c := range line {
+		if !isHexDigit(c) {
+			return 0, fmt.Errorf("%w: %q", ErrInvalidChunkSize, line)
+		}
+	}
+	size, err := strconv.ParseUint(string(line), 16, 63)
+	if err != nil {
+		return 0, fmt.Errorf("%w: chunk size too large %q", ErrInvalidChunkSize, line)
+	}
+	return size, nil
+}
+
+func isHexDigit(c byte) bool {
+	return ('0' <= c && c <= '9') || ('a' <= c && c <= 'f') || ('A' <= c && c <= 'F')
+}
@@ -0,0 +1,21 @@
+package request
+
+import "errors"
+
+// Errors returned by Parser.Next, possibly wrapped with more detail. The
+// server maps them to a response status; see server.errorStatus.
+var (
+	ErrMalformedRequestLine = errors.New("malformed request line")
+	ErrInvalidMethod        = errors.New("invalid method")
+	ErrMethodNotAllowed     = errors.New("method not allowed")
+	ErrInvalidTarget        = errors.New("invalid request-target")
+	ErrUnsupportedVersion   = errors.New("unsupported HTTP version")
+	ErrInvalidHeader        = errors.New("invalid header field")
+	ErrInvalidHost          = errors.New("invalid Host header")
+	ErrIncompleteRequest    = errors.New("incomplete request")
+	ErrTimeout              = errors.New("timed out reading request")
+
+	ErrRequestLineTooLong = errors.New("request line too long")
+	ErrHeadersTooLarge    = errors.New("request header fields too large")
+	ErrBodyTooLarge       = errors.New("request body too large")
+)
@@ -60,4 +60,11 @@ func TestRequestVariableBytesPerRead (t *testing.T) {
 	assert.Equal(t, "GET", r.RequestLine.Method)
 	assert.Equal(t, "/", r.RequestLine.RequestTarget)
 	assert.Equal(t, "1.1", r.RequestLine.HttpVersion)
-}
\ No newline at end of file
+}
+// readBody reads the whole streamed body of r
+func readBody(t *testing.T, r *Request) string {
+	t.Helper()
+	body, err := io.ReadAll(r.Body)
+	require.NoError(t, err)
+	return string(body)
+}
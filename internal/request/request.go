@@ -1,31 +1,49 @@
 package request
 
 import (
+	"bufio"
 	"bytes"
+	"context"
+	"crypto/tls"
 	"errors"
 	"fmt"
 	"httpfromtcp/internal/headers"
 	"io"
-	"strconv"
+	"os"
+	"slices"
 	"strings"
 )
 
 type Request struct {
 	RequestLine RequestLine
 	Headers headers.Headers
-	Body []byte
+	// Body streams the payload from the connection as it is read. Trailers
+	// of a chunked body are only populated once Body has returned io.EOF.
+	Body io.ReadCloser
+	// ContentLength is the declared body length, or -1 when it is unknown
+	// until the chunked body has been read.
+	ContentLength int64
+	Trailers headers.Headers
+	// TLS is the state of the connection the request arrived on, or nil if
+	// it was not encrypted. It is set by the server.
+	TLS *tls.ConnectionState
+	// RemoteAddr is the address of the client, "IP:port" for TCP. It is set
+	// by the server.
+	RemoteAddr string
 	state requestState // 0 for "initialized", 1 for "done"
-	bodyLengthRead int
+	pathValues map[string]string
+	ctx context.Context
 }
 
 type RequestLine struct {
 	HttpVersion string
 	RequestTarget string
 	Method string
+	// Target is RequestTarget parsed into its components
+	Target Target
 }
 
 const crlf = "\r\n"
-const bufferSize = 8
 
 type requestState int
 
@@ -36,56 +54,270 @@ const (
 	requestStateDone
 )
 
+// Parser reads successive requests from a single connection. It only
+// consumes the bytes belonging to the request it returns, so anything the
+// client sent after it (a pipelined request, say) is left buffered for the
+// next call to Next.
+type Parser struct {
+	reader *bufio.Reader
+	opts Options
+	body *body // body of the previous request, drained before the next one
+}
+
+// Options bounds how much a client may send. Zero values mean no limit.
+type Options struct {
+	MaxRequestLineBytes int
+	// MaxHeaderBytes bounds the header section, excluding the request line.
+	MaxHeaderBytes int
+	MaxHeaderCount int
+	MaxBodyBytes int64
+	// HeaderMode sets how strictly header and trailer fields are parsed
+	HeaderMode headers.Mode
+	// AllowedMethods, if set, lists the only methods accepted; any other
+	// fails with ErrMethodNotAllowed.
+	AllowedMethods []string
+}
+
+func DefaultOptions() Options {
+	return Options{
+		MaxRequestLineBytes: 8 << 10,
+		MaxHeaderBytes: 1 << 20,
+		MaxHeaderCount: 100,
+	}
+}
+
+// bufferSize is the size of the parser's read buffer. Longer request and
+// field lines are collected in pieces, up to the limits in Options.
+const bufferSize = 4096
+
+func NewParser(reader io.Reader) *Parser {
+	return NewParserWithOptions(reader, DefaultOptions())
+}
+
+func NewParserWithOptions(reader io.Reader, opts Options) *Parser {
+	return &Parser{
+		reader: bufio.NewReaderSize(reader, bufferSize),
+		opts: opts,
+	}
+}
+
+// Wait blocks until at least one byte of the next request has arrived,
+// which lets the caller apply an idle timeout separately from the time
+// allowed to read the request itself.
+func (p *Parser) Wait() error {
+	_, err := p.reader.Peek(1)
+	return err
+}
+
+// DiscardBody skips whatever the handler left unread of the previous
+// request's body. It fails if too much is left, in which case the
+// connection should be closed rather than reused.
+func (p *Parser) DiscardBody() error {
+	if p.body == nil {
+		return nil
+	}
+	if err := p.body.drain(); err != nil {
+		return err
+	}
+	p.body = nil
+	return nil
+}
+
+var closedChan = func() chan struct{} {
+	c := make(chan struct{})
+	close(c)
+	return c
+}()
+
+// BodyRead returns a channel that is closed once the body of the last
+// request returned by Next has been read to the end, after which the
+// connection may be read again without disturbing the handler.
+func (p *Parser) BodyRead() <-chan struct{} {
+	if p.body == nil {
+		return closedChan
+	}
+	return p.body.done
+}
+
+// BodyErr returns the error that reading the body of the last request
+// returned by Next failed with, if any, so the server can answer for a
+// handler that gave up on the body.
+func (p *Parser) BodyErr() error {
+	if p.body == nil {
+		return nil
+	}
+	return p.body.err
+}
+
 func RequestFromReader(reader io.Reader) (*Request, error) {
+	return NewParser(reader).Next()
+}
+
+// Next parses the next request. It returns io.EOF if the reader is exhausted
+// before any byte of a new request arrives.
+func (p *Parser) Next() (*Request, error) {
+	if err := p.DiscardBody(); err != nil {
+		return nil, err
+	}
+
 	r := &Request{
 		state: requestStateInitialized,
-		Headers: make(map[string]string),
-		Body: make([]byte, 0),
+		Headers: headers.NewHeaders(),
+		Body: noBody{},
+		Trailers: headers.NewHeaders(),
 	}
 
-	buf := make([]byte, bufferSize)
-	readToIndex := 0
+	headerBytes := 0
+	headerCount := 0
+	// long holds the start of a line that did not fit in the read buffer
+	var long []byte
+	for r.state != requestStateParsingBody {
+		// Parse whatever is already buffered before asking for more
+		data, _ := p.reader.Peek(p.reader.Buffered())
+		if len(long) > 0 {
+			data = append(long, data...)
+		}
+		if err := checkBareLF(data); err != nil {
+			return nil, err
+		}
+		state := r.state
+		n, err := r.parseSingle(data, p.opts.HeaderMode)
+		if err != nil {
+			return nil, err
+		}
+		if state == requestStateInitialized && n > 0 && !p.methodAllowed(r.RequestLine.Method) {
+			return nil, fmt.Errorf("%w: %s", ErrMethodNotAllowed, r.RequestLine.Method)
+		}
+
+		if n > 0 {
+			switch {
+			case state == requestStateInitialized:
+				if p.opts.MaxRequestLineBytes > 0 && n-len(crlf) > p.opts.MaxRequestLineBytes {
+					return nil, fmt.Errorf("%w: %d bytes", ErrRequestLineTooLong, n-len(crlf))
+				}
+			case r.state == requestStateParsingHeaders:
+				headerBytes += n
+				headerCount++
+				if p.opts.MaxHeaderBytes > 0 && headerBytes > p.opts.MaxHeaderBytes {
+					return nil, fmt.Errorf("%w: more than %d bytes", ErrHeadersTooLarge, p.opts.MaxHeaderBytes)
+				}
+				if p.opts.MaxHeaderCount > 0 && headerCount > p.opts.MaxHeaderCount {
+					return nil, fmt.Errorf("%w: more than %d fields", ErrHeadersTooLarge, p.opts.MaxHeaderCount)
+				}
+			}
+			p.reader.Discard(n - len(long))
+			long = nil
+			continue
+		}
 
-	for r.state != requestStateDone {
-		if readToIndex >= len(buf) {
-			newBuf := make([]byte, len(buf)*2)
-			copy(newBuf, buf)
-			buf = newBuf
+		// A partial line that is already over the limit will not get shorter
+		if err := p.checkPartialLine(state, len(data), headerBytes); err != nil {
+			return nil, err
 		}
 
-		n, err := reader.Read(buf[readToIndex:])
-		// Handle real errors
+		// Not enough data for a full line, block until more arrives
+		_, err = p.reader.Peek(len(data) - len(long) + 1)
 		if err != nil {
+			if errors.Is(err, bufio.ErrBufferFull) {
+				// Keep the line so far aside to make room for the rest of it
+				long = append(long, data[len(long):]...)
+				p.reader.Discard(p.reader.Buffered())
+				continue
+			}
 			if errors.Is(err, io.EOF) {
-				if r.state != requestStateDone {
-					return nil, fmt.Errorf("incomplete request, in state: %d, read n bytes on EOF: %d", r.state, n)
+				if len(data) == 0 && r.state == requestStateInitialized {
+					return nil, io.EOF
 				}
-				break
+				return nil, fmt.Errorf("%w: in state %d, %d bytes buffered on EOF", ErrIncompleteRequest, r.state, len(data))
+			}
+			if errors.Is(err, os.ErrDeadlineExceeded) {
+				return nil, fmt.Errorf("%w: %w", ErrTimeout, err)
 			}
 			return nil, err
 		}
-		readToIndex += n
+	}
 
-		parsedBytes, parseErr := r.parse(buf[:readToIndex])
-		if parseErr != nil {
-			return nil, parseErr
-		}
+	if err := checkHost(r); err != nil {
+		return nil, err
+	}
+	if err := p.setBody(r); err != nil {
+		return nil, err
+	}
+	r.state = requestStateDone
+	return r, nil
+}
 
-		if parsedBytes > 0 {
-			copy(buf, buf[parsedBytes:readToIndex])
-			readToIndex -= parsedBytes
-		}
+// checkHost requires exactly one Host field in HTTP/1.1 requests, and at
+// most one in HTTP/1.0 ones, which predate it (RFC 9112 section 3.2).
+func checkHost(r *Request) error {
+	switch n := len(r.Headers.Values("Host")); {
+	case n > 1:
+		return fmt.Errorf("%w: %d Host fields", ErrInvalidHost, n)
+	case n == 0 && r.RequestLine.HttpVersion != "1.0":
+		return fmt.Errorf("%w: missing Host field", ErrInvalidHost)
+	}
+	return nil
+}
 
-		if err == io.EOF {
-			if readToIndex == 0 || r.state == requestStateDone {
-				break
-			}
+func (p *Parser) methodAllowed(method string) bool {
+	return p.opts.AllowedMethods == nil || slices.Contains(p.opts.AllowedMethods, method)
+}
 
-			return nil, errors.New("error: incomplete request")
+// checkPartialLine rejects an unterminated line that already exceeds the
+// limits. The extra byte allows for a trailing CR whose LF is yet to come.
+func (p *Parser) checkPartialLine(state requestState, n int, headerBytes int) error {
+	if state == requestStateInitialized {
+		if p.opts.MaxRequestLineBytes > 0 && n > p.opts.MaxRequestLineBytes+1 {
+			return fmt.Errorf("%w: more than %d bytes", ErrRequestLineTooLong, p.opts.MaxRequestLineBytes)
 		}
+		return nil
 	}
+	if p.opts.MaxHeaderBytes > 0 && headerBytes+n > p.opts.MaxHeaderBytes+1 {
+		return fmt.Errorf("%w: more than %d bytes", ErrHeadersTooLarge, p.opts.MaxHeaderBytes)
+	}
+	return nil
+}
 
-	return r, nil
+// setBody attaches a reader for the message body, framed either by chunked
+// Transfer-Encoding or by Content-Length. With neither the request has no
+// body, and nothing further is consumed from the connection.
+func (p *Parser) setBody(r *Request) error {
+	f, err := checkFraming(r.RequestLine.HttpVersion, r.Headers)
+	if err != nil {
+		return err
+	}
+
+	if f.chunked {
+		cr := newChunkedReader(p.reader, r.Trailers, p.opts)
+		p.body = newBody(p.limitBody(cr))
+		p.body.chunked = cr
+		r.Body = p.body
+		r.ContentLength = -1
+		return nil
+	}
+
+	if f.contentLength == -1 {
+		return nil
+	}
+	if p.opts.MaxBodyBytes > 0 && f.contentLength > p.opts.MaxBodyBytes {
+		return fmt.Errorf("%w: Content-Length %d exceeds %d bytes", ErrBodyTooLarge, f.contentLength, p.opts.MaxBodyBytes)
+	}
+
+	r.ContentLength = f.contentLength
+	if f.contentLength > 0 {
+		p.body = newBody(&lengthReader{reader: p.reader, remaining: f.contentLength})
+		r.Body = p.body
+	}
+	return nil
+}
+
+// limitBody enforces MaxBodyBytes on bodies whose length is not declared
+// up front.
+func (p *Parser) limitBody(src io.Reader) io.Reader {
+	if p.opts.MaxBodyBytes <= 0 {
+		return src
+	}
+	return &maxBytesReader{src: src, remaining: p.opts.MaxBodyBytes}
 }
 
 func parseRequestLine(data []byte) (requestline *RequestLine, numBytes int, err error) {
@@ -105,7 +337,7 @@ func parseRequestLine(data []byte) (requestline *RequestLine, numBytes int, err
 func requestLineFromString(str string) (*RequestLine, error) {
 	parts := strings.Split(str, " ")
 	if len(parts) != 3 {
-		return nil, fmt.Errorf("poorly formatted request-line: %s", str)
+		return nil, fmt.Errorf("%w: %q", ErrMalformedRequestLine, str)
 	}
 
 	method := parts[0]
@@ -113,34 +345,51 @@ func requestLineFromString(str string) (*RequestLine, error) {
 	// Checks for only capital letters
 	for _, c := range method {
 		if c < 'A' || c > 'Z' {
-			return nil, fmt.Errorf("invalid method: %s", method)
+			return nil, fmt.Errorf("%w: %q", ErrInvalidMethod, method)
 		}
 	}
 
 	requestTarget := parts[1]
 
-	versionParts := strings.Split(parts[2], "/")
-	if len(versionParts) != 2 {
-		return nil, fmt.Errorf("malformed start-line %s", str)
+	version, ok := strings.CutPrefix(parts[2], "HTTP/")
+	if !ok || !validVersion(version) {
+		return nil, fmt.Errorf("%w: bad HTTP-version %q", ErrMalformedRequestLine, parts[2])
 	}
-
-	httpPart := versionParts[0]
-	if httpPart != "HTTP" {
-		return nil, fmt.Errorf("unrecognized HTTP-version: %s", httpPart)
+	if version != "1.1" && version != "1.0" {
+		return nil, fmt.Errorf("%w: HTTP/%s", ErrUnsupportedVersion, version)
 	}
-	version := versionParts[1]
-	if version != "1.1" {
-		return nil, fmt.Errorf("unrecognized HTTP-version: %s", version)
+
+	target, err := parseTarget(method, requestTarget)
+	if err != nil {
+		return nil, err
 	}
 
 	return &RequestLine{
 		Method: method,
 		RequestTarget: requestTarget,
-		HttpVersion: versionParts[1],
+		HttpVersion: version,
+		Target: target,
 	}, nil
 }
 
-func (r *Request) parseSingle(data []byte) (int, error) {
+// validVersion checks the DIGIT "." DIGIT of an HTTP-version. A lone major
+// version such as "2" is accepted too, so that an HTTP/2 preface is
+// reported as unsupported rather than malformed.
+func validVersion(version string) bool {
+	switch len(version) {
+	case 1:
+		return isDigit(version[0])
+	case 3:
+		return isDigit(version[0]) && version[1] == '.' && isDigit(version[2])
+	}
+	return false
+}
+
+func isDigit(c byte) bool {
+	return '0' <= c && c <= '9'
+}
+
+func (r *Request) parseSingle(data []byte, headerMode headers.Mode) (int, error) {
 	switch r.state {
 	case requestStateInitialized:
 		requestLine, n, err := parseRequestLine(data)
@@ -159,35 +408,15 @@ func (r *Request) parseSingle(data []byte) (int, error) {
 		r.state = requestStateParsingHeaders
 		return n, nil
 	case requestStateParsingHeaders:
-		n, done, err := r.Headers.Parse(data)
+		n, done, err := r.Headers.ParseMode(data, headerMode)
 		if err != nil {
-			return 0, err
+			return 0, fmt.Errorf("%w: %w", ErrInvalidHeader, err)
 		}
 
 		if done {
 			r.state = requestStateParsingBody
 		}
 		return n, nil
-	case requestStateParsingBody:
-		contentLenStr, ok := r.Headers.Get("Content-Length")
-		if !ok {
-			r.state = requestStateDone
-			return len(data), nil
-		}
-		contentLen, err := strconv.Atoi(contentLenStr)
-		if err != nil {
-			return 0, fmt.Errorf("error: Content-Length could not be converted to an integer: %s", contentLenStr)
-		}
-		r.Body = append(r.Body, data...)
-		r.bodyLengthRead += len(data)
-		if r.bodyLengthRead > contentLen {
-			return 0, fmt.Errorf("error: Content-Length too large")
-		}
-		if r.bodyLengthRead == contentLen {
-			r.state = requestStateDone
-		}
-		return len(data), nil
-
 	case requestStateDone:
 		return 0, fmt.Errorf("error: trying to read data in a done state")
 	default:
@@ -195,21 +424,38 @@ func (r *Request) parseSingle(data []byte) (int, error) {
 	}
 }
 
-func (r *Request) parse(data []byte) (int, error) {
-	totalBytesParsed := 0
-	for r.state != requestStateDone {
-		n, err := r.parseSingle(data[totalBytesParsed:])
-		if err != nil {
-			return totalBytesParsed, err
-		}
-
-		// If no bytes were parsed, we need more data
-		if n == 0 {
-			break
-		}
+// Context returns the request's context. The server cancels it when the
+// client goes away, the response cannot be written or the server is closed.
+// It is never nil.
+func (r *Request) Context() context.Context {
+	if r.ctx == nil {
+		return context.Background()
+	}
+	return r.ctx
+}
 
-		totalBytesParsed += n
+// WithContext returns a shallow copy of r with its context changed to ctx,
+// for middleware that adds values or deadlines. The copy gets its own
+// header fields but shares Body and Trailers with r.
+func (r *Request) WithContext(ctx context.Context) *Request {
+	if ctx == nil {
+		panic("nil context")
 	}
+	r2 := *r
+	r2.ctx = ctx
+	r2.Headers = r.Headers.Clone()
+	return &r2
+}
+
+// PathValue returns a value captured from the path by a router, or "" if
+// there is none by that name.
+func (r *Request) PathValue(name string) string {
+	return r.pathValues[name]
+}
 
-	return totalBytesParsed, nil
-}
\ No newline at end of file
+func (r *Request) SetPathValue(name, value string) {
+	if r.pathValues == nil {
+		r.pathValues = make(map[string]string)
+	}
+	r.pathValues[name] = value
+}
@@ -1,6 +1,9 @@
 package request
 
 import (
+	"context"
+	"httpfromtcp/internal/headers"
+	"io"
 	"strings"
 	"testing"
 
@@ -43,15 +46,15 @@ func TestRequestLineParse (t *testing.T) {
 
 	// Test: Invalid number of parts in request line
 	_, err = RequestFromReader(strings.NewReader("/coffee HTTP/1.1\r\nHost: localhost:42069\r\nUser-Agent: curl/7.81.0\r\nAccept: */*\r\n\r\n"))
-	require.Error(t, err)
+	require.ErrorIs(t, err, ErrMalformedRequestLine)
 
 	// Test: Invalid method (out of order) Request line
 	_, err = RequestFromReader(strings.NewReader("/coffee GET HTTP/1.1\r\nHost: localhost:42069\r\nUser-Agent: curl/7.81.0\r\nAccept: */*\r\n\r\n"))
-	require.Error(t, err)
+	require.ErrorIs(t, err, ErrInvalidMethod)
 
 	// Test: Invalid version in Request line
 	_, err = RequestFromReader(strings.NewReader("GET /coffee HTTP/2\r\nHost: localhost:42069\r\nUser-Agent: curl/7.81.0\r\nAccept: */*\r\n\r\n"))
-	require.Error(t, err)
+	require.ErrorIs(t, err, ErrUnsupportedVersion)
 }
 
 func TestRequestHeadersParse (t *testing.T) {
@@ -63,9 +66,9 @@ func TestRequestHeadersParse (t *testing.T) {
 	r, err := RequestFromReader(reader)
 	require.NoError(t, err)
 	require.NotNil(t, r)
-	assert.Equal(t, "localhost:42069", r.Headers["host"])
-	assert.Equal(t, "curl/7.81.0", r.Headers["user-agent"])
-	assert.Equal(t, "*/*", r.Headers["accept"])
+	assert.Equal(t, []string{"localhost:42069"}, r.Headers.Values("host"))
+	assert.Equal(t, []string{"curl/7.81.0"}, r.Headers.Values("user-agent"))
+	assert.Equal(t, []string{"*/*"}, r.Headers.Values("accept"))
 
 	// Test: Empty Headers
 	reader = &chunkReader{
@@ -91,8 +94,8 @@ func TestRequestHeadersParse (t *testing.T) {
 	r, err = RequestFromReader(reader)
 	require.NoError(t, err)
 	require.NotNil(t, r)
-	assert.Equal(t, "localhost:42069", r.Headers["host"])
-	assert.Equal(t, "curl/7.81.0, curl/8.4.0", r.Headers["user-agent"])
+	assert.Equal(t, []string{"localhost:42069"}, r.Headers.Values("host"))
+	assert.Equal(t, []string{"curl/7.81.0", "curl/8.4.0"}, r.Headers.Values("user-agent"))
 
 	// Test: Case Insensitive Headers
 	reader = &chunkReader{
@@ -102,9 +105,9 @@ func TestRequestHeadersParse (t *testing.T) {
 	r, err = RequestFromReader(reader)
 	require.NoError(t, err)
 	require.NotNil(t, r)
-	assert.Equal(t, "localhost:42069", r.Headers["host"])
-	assert.Equal(t, "curl/7.81.0", r.Headers["user-agent"])
-	assert.Equal(t, "*/*", r.Headers["accept"])
+	assert.Equal(t, []string{"localhost:42069"}, r.Headers.Values("host"))
+	assert.Equal(t, []string{"curl/7.81.0"}, r.Headers.Values("user-agent"))
+	assert.Equal(t, []string{"*/*"}, r.Headers.Values("accept"))
 
 	
 	// Test: Missing End of Headers
@@ -129,7 +132,7 @@ func TestRequestBodyParse (t *testing.T) {
 	r, err := RequestFromReader(reader)
 	require.NoError(t, err)
 	require.NotNil(t, r)
-	assert.Equal(t, "hello world!\n", string(r.Body))
+	assert.Equal(t, "hello world!\n", readBody(t, r))
 
 	// Test: Empty Body, zero reported content length
 	reader = &chunkReader{
@@ -142,7 +145,7 @@ func TestRequestBodyParse (t *testing.T) {
 	r, err = RequestFromReader(reader)
 	require.NoError(t, err)
 	require.NotNil(t, r)
-	assert.Equal(t, "", string(r.Body))
+	assert.Equal(t, "", readBody(t, r))
 
 	// Test: Body shorter than reported content length
 	reader = &chunkReader{
@@ -153,8 +156,10 @@ func TestRequestBodyParse (t *testing.T) {
 			"partial content",
 		numBytesPerRead: 3,
 	}
-	_, err = RequestFromReader(reader)
-	require.Error(t, err)
+	r, err = RequestFromReader(reader)
+	require.NoError(t, err)
+	_, err = io.ReadAll(r.Body)
+	require.ErrorIs(t, err, io.ErrUnexpectedEOF)
 
 	// Test: No Content-Length but Body Exists
 	reader = &chunkReader{
@@ -167,5 +172,323 @@ func TestRequestBodyParse (t *testing.T) {
 	r, err = RequestFromReader(reader)
 	require.NoError(t, err)
 	require.NotNil(t, r)
-	assert.Equal(t, "", string(r.Body))
-}
\ No newline at end of file
+	assert.Equal(t, "", readBody(t, r))
+}
+func TestParserMultipleRequests (t *testing.T) {
+	// Test: Pipelined requests on one connection
+	reader := &chunkReader{
+		data: "POST /first HTTP/1.1\r\n" +
+			"Host: localhost:42069\r\n" +
+			"Content-Length: 5\r\n" +
+			"\r\n" +
+			"hello" +
+			"GET /second HTTP/1.1\r\n" +
+			"Host: localhost:42069\r\n" +
+			"\r\n",
+		numBytesPerRead: 7,
+	}
+	p := NewParser(reader)
+	r, err := p.Next()
+	require.NoError(t, err)
+	assert.Equal(t, "/first", r.RequestLine.RequestTarget)
+	assert.Equal(t, "hello", readBody(t, r))
+
+	r, err = p.Next()
+	require.NoError(t, err)
+	assert.Equal(t, "/second", r.RequestLine.RequestTarget)
+	assert.Equal(t, "", readBody(t, r))
+
+	// Test: Clean EOF between requests
+	_, err = p.Next()
+	require.ErrorIs(t, err, io.EOF)
+
+	// Test: Unread body is drained before the next request
+	p = NewParser(strings.NewReader("POST /first HTTP/1.1\r\nHost: localhost:42069\r\nContent-Length: 5\r\n\r\nhello" +
+		"GET /second HTTP/1.1\r\nHost: localhost:42069\r\n\r\n"))
+	r, err = p.Next()
+	require.NoError(t, err)
+	assert.Equal(t, int64(5), r.ContentLength)
+	r, err = p.Next()
+	require.NoError(t, err)
+	assert.Equal(t, "/second", r.RequestLine.RequestTarget)
+
+	// Test: No Content-Length does not consume the next request
+	p = NewParser(strings.NewReader("GET /a HTTP/1.1\r\nHost: localhost:42069\r\n\r\nGET /b HTTP/1.1\r\nHost: localhost:42069\r\n\r\n"))
+	r, err = p.Next()
+	require.NoError(t, err)
+	assert.Equal(t, "/a", r.RequestLine.RequestTarget)
+	r, err = p.Next()
+	require.NoError(t, err)
+	assert.Equal(t, "/b", r.RequestLine.RequestTarget)
+}
+
+func TestRequestChunkedBodyParse (t *testing.T) {
+	// Test: Standard chunked body
+	reader := &chunkReader{
+		data: "POST /submit HTTP/1.1\r\n" +
+			"Host: localhost:42069\r\n" +
+			"Transfer-Encoding: chunked\r\n" +
+			"\r\n" +
+			"6\r\nhello \r\n" +
+			"7\r\nworld!\n\r\n" +
+			"0\r\n" +
+			"\r\n",
+		numBytesPerRead: 3,
+	}
+	r, err := RequestFromReader(reader)
+	require.NoError(t, err)
+	require.NotNil(t, r)
+	assert.Equal(t, "hello world!\n", readBody(t, r))
+
+	// Test: Chunk extensions and trailers
+	reader = &chunkReader{
+		data: "POST /submit HTTP/1.1\r\n" +
+			"Host: localhost:42069\r\n" +
+			"Transfer-Encoding: chunked\r\n" +
+			"\r\n" +
+			"A;name=value\r\n0123456789\r\n" +
+			"0;last\r\n" +
+			"X-Checksum: abc123\r\n" +
+			"\r\n",
+		numBytesPerRead: 5,
+	}
+	r, err = RequestFromReader(reader)
+	require.NoError(t, err)
+	assert.Equal(t, "0123456789", readBody(t, r))
+	assert.Equal(t, []string{"abc123"}, r.Trailers.Values("x-checksum"))
+
+	// Test: Both Transfer-Encoding and Content-Length
+	_, err = RequestFromReader(strings.NewReader("POST /submit HTTP/1.1\r\n" +
+		"Host: localhost:42069\r\n" +
+		"Transfer-Encoding: chunked\r\n" +
+		"Content-Length: 5\r\n" +
+		"\r\n" +
+		"5\r\nhello\r\n0\r\n\r\n"))
+	require.Error(t, err)
+
+	// Test: Invalid chunk size
+	r, err = RequestFromReader(strings.NewReader("POST /submit HTTP/1.1\r\n" +
+		"Host: localhost:42069\r\n" +
+		"Transfer-Encoding: chunked\r\n" +
+		"\r\n" +
+		"zz\r\nhello\r\n0\r\n\r\n"))
+	require.NoError(t, err)
+	_, err = io.ReadAll(r.Body)
+	require.Error(t, err)
+
+	// Test: Chunk data longer than its size
+	r, err = RequestFromReader(strings.NewReader("POST /submit HTTP/1.1\r\n" +
+		"Host: localhost:42069\r\n" +
+		"Transfer-Encoding: chunked\r\n" +
+		"\r\n" +
+		"3\r\nhello\r\n0\r\n\r\n"))
+	require.NoError(t, err)
+	_, err = io.ReadAll(r.Body)
+	require.Error(t, err)
+
+	// Test: Missing last chunk
+	r, err = RequestFromReader(strings.NewReader("POST /submit HTTP/1.1\r\n" +
+		"Host: localhost:42069\r\n" +
+		"Transfer-Encoding: chunked\r\n" +
+		"\r\n" +
+		"5\r\nhello\r\n"))
+	require.NoError(t, err)
+	_, err = io.ReadAll(r.Body)
+	require.Error(t, err)
+
+	// Test: Unsupported transfer coding
+	_, err = RequestFromReader(strings.NewReader("POST /submit HTTP/1.1\r\n" +
+		"Host: localhost:42069\r\n" +
+		"Transfer-Encoding: gzip\r\n" +
+		"\r\n"))
+	require.Error(t, err)
+}
+
+func TestRequestStreamingBody (t *testing.T) {
+	// Test: Request is returned before the body has arrived
+	pr, pw := io.Pipe()
+	go func() {
+		pw.Write([]byte("POST /upload HTTP/1.1\r\nHost: localhost:42069\r\nContent-Length: 10\r\n\r\n"))
+		pw.Write([]byte("01234"))
+	}()
+	r, err := RequestFromReader(pr)
+	require.NoError(t, err)
+	buf := make([]byte, 10)
+	n, err := r.Body.Read(buf)
+	require.NoError(t, err)
+	assert.Equal(t, "01234", string(buf[:n]))
+
+	go pw.Write([]byte("56789"))
+	n, err = io.ReadFull(r.Body, buf[:5])
+	require.NoError(t, err)
+	assert.Equal(t, "56789", string(buf[:n]))
+
+	// Test: Reading a closed body fails
+	require.NoError(t, r.Body.Close())
+	_, err = r.Body.Read(buf)
+	require.Error(t, err)
+}
+
+func TestRequestLimits (t *testing.T) {
+	opts := Options{
+		MaxRequestLineBytes: 32,
+		MaxHeaderBytes: 64,
+		MaxHeaderCount: 3,
+		MaxBodyBytes: 10,
+	}
+
+	// Test: Request line within limits
+	reader := &chunkReader{
+		data: "GET /coffee HTTP/1.1\r\nHost: localhost:42069\r\n\r\n",
+		numBytesPerRead: 3,
+	}
+	_, err := NewParserWithOptions(reader, opts).Next()
+	require.NoError(t, err)
+
+	// Test: Request line too long
+	reader = &chunkReader{
+		data: "GET /" + strings.Repeat("a", 64) + " HTTP/1.1\r\nHost: localhost:42069\r\n\r\n",
+		numBytesPerRead: 3,
+	}
+	_, err = NewParserWithOptions(reader, opts).Next()
+	require.ErrorIs(t, err, ErrRequestLineTooLong)
+
+	// Test: Request line never terminated
+	_, err = NewParserWithOptions(strings.NewReader("GET /"+strings.Repeat("a", 8192)), opts).Next()
+	require.ErrorIs(t, err, ErrRequestLineTooLong)
+
+	// Test: Header section too large
+	reader = &chunkReader{
+		data: "GET / HTTP/1.1\r\nHost: localhost:42069\r\nX-Long: " + strings.Repeat("b", 64) + "\r\n\r\n",
+		numBytesPerRead: 3,
+	}
+	_, err = NewParserWithOptions(reader, opts).Next()
+	require.ErrorIs(t, err, ErrHeadersTooLarge)
+
+	// Test: Too many header fields
+	_, err = NewParserWithOptions(strings.NewReader("GET / HTTP/1.1\r\nA: 1\r\nB: 2\r\nC: 3\r\nD: 4\r\n\r\n"), opts).Next()
+	require.ErrorIs(t, err, ErrHeadersTooLarge)
+
+	// Test: Content-Length over the limit
+	_, err = NewParserWithOptions(strings.NewReader("POST / HTTP/1.1\r\nHost: a\r\nContent-Length: 11\r\n\r\nhello world"), opts).Next()
+	require.ErrorIs(t, err, ErrBodyTooLarge)
+
+	// Test: Chunked body over the limit
+	r, err := NewParserWithOptions(strings.NewReader("POST / HTTP/1.1\r\nHost: a\r\nTransfer-Encoding: chunked\r\n\r\n6\r\nhello \r\n6\r\nworld!\r\n0\r\n\r\n"), opts).Next()
+	require.NoError(t, err)
+	_, err = io.ReadAll(r.Body)
+	require.ErrorIs(t, err, ErrBodyTooLarge)
+
+	// Test: Chunked body exactly at the limit
+	r, err = NewParserWithOptions(strings.NewReader("POST / HTTP/1.1\r\nHost: a\r\nTransfer-Encoding: chunked\r\n\r\n5\r\nhello\r\n5\r\nworld\r\n0\r\n\r\n"), opts).Next()
+	require.NoError(t, err)
+	assert.Equal(t, "helloworld", readBody(t, r))
+
+	// Test: Field lines longer than the read buffer, up to MaxHeaderBytes
+	r, err = NewParser(strings.NewReader("GET / HTTP/1.1\r\nHost: a\r\nCookie: " + strings.Repeat("c", 9000) + "\r\n\r\n")).Next()
+	require.NoError(t, err)
+	cookie, _ := r.Headers.Get("Cookie")
+	assert.Len(t, cookie, 9000)
+
+	// Test: No request line limit
+	reader = &chunkReader{
+		data: "GET /" + strings.Repeat("a", 5000) + " HTTP/1.1\r\nHost: a\r\n\r\n",
+		numBytesPerRead: 1000,
+	}
+	r, err = NewParserWithOptions(reader, Options{}).Next()
+	require.NoError(t, err)
+	assert.Len(t, r.RequestLine.RequestTarget, 5001)
+
+	// Test: Trailer section held to the header limits
+	r, err = NewParserWithOptions(strings.NewReader("POST / HTTP/1.1\r\nHost: a\r\nTransfer-Encoding: chunked\r\n\r\n0\r\n"+
+		"A: 1\r\nB: 2\r\nC: 3\r\nD: 4\r\n\r\n"), opts).Next()
+	require.NoError(t, err)
+	_, err = io.ReadAll(r.Body)
+	require.ErrorIs(t, err, ErrHeadersTooLarge)
+	r, err = NewParserWithOptions(strings.NewReader("POST / HTTP/1.1\r\nHost: a\r\nTransfer-Encoding: chunked\r\n\r\n0\r\n"+
+		"X-Long: "+strings.Repeat("b", 64)+"\r\n\r\n"), opts).Next()
+	require.NoError(t, err)
+	_, err = io.ReadAll(r.Body)
+	require.ErrorIs(t, err, ErrHeadersTooLarge)
+
+	// Test: Unread trailers and chunk extensions count towards the drain
+	// limit
+	p := NewParserWithOptions(strings.NewReader("POST / HTTP/1.1\r\nHost: a\r\nTransfer-Encoding: chunked\r\n\r\n0\r\n"+
+		strings.Repeat("X-Pad: "+strings.Repeat("p", 100)+"\r\n", maxDrainBytes/100)+"\r\n"), Options{})
+	_, err = p.Next()
+	require.NoError(t, err)
+	require.Error(t, p.DiscardBody())
+	p = NewParserWithOptions(strings.NewReader("POST / HTTP/1.1\r\nHost: a\r\nTransfer-Encoding: chunked\r\n\r\n"+
+		strings.Repeat("1;"+strings.Repeat("e", 100)+"\r\nx\r\n", maxDrainBytes/100)+"0\r\n\r\n"), Options{})
+	_, err = p.Next()
+	require.NoError(t, err)
+	require.Error(t, p.DiscardBody())
+}
+
+func TestRequestErrors (t *testing.T) {
+	// Test: Garbage HTTP-version is malformed, not unsupported
+	_, err := RequestFromReader(strings.NewReader("GET / HTTP/one\r\nHost: localhost:42069\r\n\r\n"))
+	require.ErrorIs(t, err, ErrMalformedRequestLine)
+
+	// Test: Well-formed but unsupported HTTP-version
+	_, err = RequestFromReader(strings.NewReader("GET / HTTP/3.0\r\nHost: localhost:42069\r\n\r\n"))
+	require.ErrorIs(t, err, ErrUnsupportedVersion)
+
+	// Test: Invalid request-target
+	_, err = RequestFromReader(strings.NewReader("GET /a%zz HTTP/1.1\r\nHost: localhost:42069\r\n\r\n"))
+	require.ErrorIs(t, err, ErrInvalidTarget)
+
+	// Test: Invalid header keeps the headers package error in the chain
+	_, err = RequestFromReader(strings.NewReader("GET / HTTP/1.1\r\nHost: localhost:42069\r\nX-Folded: a\r\n b\r\n\r\n"))
+	require.ErrorIs(t, err, ErrInvalidHeader)
+	require.ErrorIs(t, err, headers.ErrObsoleteLineFolding)
+
+	// Test: Connection closed mid-request
+	_, err = RequestFromReader(strings.NewReader("GET / HTTP/1.1\r\nHost: local"))
+	require.ErrorIs(t, err, ErrIncompleteRequest)
+
+	// Test: Method outside AllowedMethods
+	opts := DefaultOptions()
+	opts.AllowedMethods = []string{"GET", "HEAD"}
+	_, err = NewParserWithOptions(strings.NewReader("DELETE / HTTP/1.1\r\nHost: localhost:42069\r\n\r\n"), opts).Next()
+	require.ErrorIs(t, err, ErrMethodNotAllowed)
+	_, err = NewParserWithOptions(strings.NewReader("HEAD / HTTP/1.1\r\nHost: localhost:42069\r\n\r\n"), opts).Next()
+	require.NoError(t, err)
+}
+
+func TestRequestHTTPVersions (t *testing.T) {
+	// Test: HTTP/1.0 without Host
+	r, err := RequestFromReader(strings.NewReader("GET / HTTP/1.0\r\nUser-Agent: curl/7.81.0\r\n\r\n"))
+	require.NoError(t, err)
+	assert.Equal(t, "1.0", r.RequestLine.HttpVersion)
+
+	// Test: HTTP/1.1 requires Host
+	_, err = RequestFromReader(strings.NewReader("GET / HTTP/1.1\r\nUser-Agent: curl/7.81.0\r\n\r\n"))
+	require.ErrorIs(t, err, ErrInvalidHost)
+
+	// Test: Duplicate Host in either version
+	_, err = RequestFromReader(strings.NewReader("GET / HTTP/1.0\r\nHost: a\r\nHost: b\r\n\r\n"))
+	require.ErrorIs(t, err, ErrInvalidHost)
+
+	// Test: HTTP/2 and later are unsupported
+	_, err = RequestFromReader(strings.NewReader("PRI * HTTP/2.0\r\n\r\nSM\r\n\r\n"))
+	require.ErrorIs(t, err, ErrUnsupportedVersion)
+	_, err = RequestFromReader(strings.NewReader("GET / HTTP/3\r\nHost: a\r\n\r\n"))
+	require.ErrorIs(t, err, ErrUnsupportedVersion)
+}
+
+func TestRequestContext (t *testing.T) {
+	r, err := RequestFromReader(strings.NewReader("GET / HTTP/1.1\r\nHost: a\r\n\r\n"))
+	require.NoError(t, err)
+
+	// Test: Default context
+	assert.Equal(t, context.Background(), r.Context())
+
+	// Test: WithContext copies the request
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	r2 := r.WithContext(ctx)
+	assert.Equal(t, ctx, r2.Context())
+	assert.Equal(t, context.Background(), r.Context())
+	assert.Equal(t, r.RequestLine, r2.RequestLine)
+}
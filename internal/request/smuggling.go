@@ -0,0 +1,136 @@
+package request
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"httpfromtcp/internal/headers"
+	"strconv"
+	"strings"
+)
+
+// Request smuggling defences. Smuggling works by getting two HTTP
+// implementations, say a proxy and this server, to disagree about where a
+// message ends. Every check here refuses input that some implementation
+// somewhere would frame differently, instead of picking one reading.
+var (
+	// Both Transfer-Encoding and Content-Length (CL.TE / TE.CL)
+	ErrContentLengthWithTransferEncoding = errors.New("request has both Transfer-Encoding and Content-Length")
+	// Several Content-Length values that do not agree
+	ErrConflictingContentLength = errors.New("conflicting Content-Length values")
+	// Content-Length that is not a plain decimal number, e.g. "+10" or "0x10"
+	ErrInvalidContentLength = errors.New("invalid Content-Length")
+	// Transfer codings other than a single, final "chunked" (TE.TE)
+	ErrUnsupportedTransferEncoding = errors.New("unsupported Transfer-Encoding")
+	// Transfer-Encoding in an HTTP/1.0 request, which HTTP/1.0 recipients
+	// do not know about
+	ErrTransferEncodingHTTP10 = errors.New("Transfer-Encoding in HTTP/1.0 request")
+	// Chunk size that is not plain hex, or chunk data that does not match it
+	ErrInvalidChunkSize = errors.New("invalid chunk size")
+	// Line terminated by LF alone instead of CRLF
+	ErrBareLF = errors.New("line terminated by bare LF")
+)
+
+// framing is how a request body is delimited.
+type framing struct {
+	chunked bool
+	// contentLength is -1 when there is no Content-Length
+	contentLength int64
+}
+
+// checkFraming decides how the body is delimited, per RFC 9112 section 6.3,
+// rejecting every ambiguous combination.
+func checkFraming(version string, h headers.Headers) (framing, error) {
+	transferEncodings := h.Values("Transfer-Encoding")
+	contentLengths := h.Values("Content-Length")
+
+	if len(transferEncodings) > 0 {
+		// The framing of such a message must be treated as faulty (RFC 9112
+		// section 6.1)
+		if version == "1.0" {
+			return framing{}, ErrTransferEncodingHTTP10
+		}
+		if len(contentLengths) > 0 {
+			return framing{}, ErrContentLengthWithTransferEncoding
+		}
+		if err := checkTransferEncoding(transferEncodings); err != nil {
+			return framing{}, err
+		}
+		return framing{chunked: true, contentLength: -1}, nil
+	}
+
+	if len(contentLengths) == 0 {
+		return framing{contentLength: -1}, nil
+	}
+	contentLength, err := parseContentLength(contentLengths)
+	if err != nil {
+		return framing{}, err
+	}
+	return framing{contentLength: contentLength}, nil
+}
+
+// checkTransferEncoding accepts exactly one transfer coding, chunked, across
+// all Transfer-Encoding lines.
+func checkTransferEncoding(values []string) error {
+	var codings []string
+	for _, value := range values {
+		for _, coding := range strings.Split(value, ",") {
+			coding = strings.Trim(coding, " \t")
+			if coding != "" {
+				codings = append(codings, coding)
+			}
+		}
+	}
+	if len(codings) != 1 || !strings.EqualFold(codings[0], "chunked") {
+		return fmt.Errorf("%w: %s", ErrUnsupportedTransferEncoding, strings.Join(values, ", "))
+	}
+	return nil
+}
+
+// parseContentLength accepts repeated Content-Length lines, or a list in
+// one line, only if every value is the same decimal number (RFC 9110
+// section 8.6).
+func parseContentLength(values []string) (int64, error) {
+	contentLength := int64(-1)
+	for _, value := range values {
+		for _, part := range strings.Split(value, ",") {
+			part = strings.Trim(part, " \t")
+			n, err := parseDecimal(part)
+			if err != nil {
+				return 0, fmt.Errorf("%w: %q", ErrInvalidContentLength, value)
+			}
+			if contentLength != -1 && n != contentLength {
+				return 0, fmt.Errorf("%w: %s", ErrConflictingContentLength, strings.Join(values, ", "))
+			}
+			contentLength = n
+		}
+	}
+	return contentLength, nil
+}
+
+// parseDecimal is strconv.ParseInt without the leniency: digits only, so no
+// sign, no underscores and no whitespace.
+func parseDecimal(s string) (int64, error) {
+	if s == "" {
+		return 0, fmt.Errorf("empty number")
+	}
+	for i := 0; i < len(s); i++ {
+		if s[i] < '0' || s[i] > '9' {
+			return 0, fmt.Errorf("invalid digit %q", s[i])
+		}
+	}
+	return strconv.ParseInt(s, 10, 64)
+}
+
+// checkBareLF looks at buffered data starting at a line boundary and
+// rejects the line if its first LF is not preceded by CR.
+func checkBareLF(data []byte) error {
+	idx := bytes.IndexByte(data, '\n')
+	if idx == -1 {
+		return nil
+	}
+	if idx == 0 || data[idx-1] != '\r' {
+		return ErrBareLF
+	}
+	return nil
+}
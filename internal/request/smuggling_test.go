@@ -0,0 +1,211 @@
+package request
+
+import (
+	"io"
+	"strings"
+	"testing"
+
+	"httpfromtcp/internal/headers"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// smugglingPayloads are requests that some HTTP implementation frames
+// differently from another. Each must be refused, either by Next or when
+// the body is read.
+var smugglingPayloads = []struct {
+	name    string
+	data    string
+	wantErr error
+}{
+	{
+		name: "CL.TE",
+		data: "POST / HTTP/1.1\r\nHost: a\r\nContent-Length: 13\r\nTransfer-Encoding: chunked\r\n\r\n0\r\n\r\nSMUGGLED",
+		wantErr: ErrContentLengthWithTransferEncoding,
+	},
+	{
+		name: "TE.CL",
+		data: "POST / HTTP/1.1\r\nHost: a\r\nTransfer-Encoding: chunked\r\nContent-Length: 3\r\n\r\n8\r\nSMUGGLED\r\n0\r\n\r\n",
+		wantErr: ErrContentLengthWithTransferEncoding,
+	},
+	{
+		name: "TE.TE unknown coding",
+		data: "POST / HTTP/1.1\r\nHost: a\r\nTransfer-Encoding: xchunked\r\n\r\n",
+		wantErr: ErrUnsupportedTransferEncoding,
+	},
+	{
+		name: "TE.TE second line",
+		data: "POST / HTTP/1.1\r\nHost: a\r\nTransfer-Encoding: chunked\r\nTransfer-Encoding: x\r\n\r\n",
+		wantErr: ErrUnsupportedTransferEncoding,
+	},
+	{
+		name: "TE.TE chunked twice",
+		data: "POST / HTTP/1.1\r\nHost: a\r\nTransfer-Encoding: chunked, chunked\r\n\r\n",
+		wantErr: ErrUnsupportedTransferEncoding,
+	},
+	{
+		name: "TE.TE chunked not last",
+		data: "POST / HTTP/1.1\r\nHost: a\r\nTransfer-Encoding: chunked, identity\r\n\r\n",
+		wantErr: ErrUnsupportedTransferEncoding,
+	},
+	{
+		name: "TE.TE obs-fold",
+		data: "POST / HTTP/1.1\r\nHost: a\r\nX-Padding: x\r\n Transfer-Encoding: chunked\r\n\r\n",
+		wantErr: headers.ErrObsoleteLineFolding,
+	},
+	{
+		name: "CL after request line whitespace",
+		data: "POST / HTTP/1.1\r\n Content-Length: 5\r\nHost: a\r\n\r\nhello",
+		wantErr: headers.ErrLeadingWhitespace,
+	},
+	{
+		name: "TE after request line whitespace",
+		data: "POST / HTTP/1.1\r\n Transfer-Encoding: chunked\r\nHost: a\r\n\r\n0\r\n\r\n",
+		wantErr: headers.ErrLeadingWhitespace,
+	},
+	{
+		name: "TE in HTTP/1.0",
+		data: "POST / HTTP/1.0\r\nTransfer-Encoding: chunked\r\n\r\n5\r\nhello\r\n0\r\n\r\n",
+		wantErr: ErrTransferEncodingHTTP10,
+	},
+	{
+		name: "TE and CL in HTTP/1.0",
+		data: "POST / HTTP/1.0\r\nTransfer-Encoding: chunked\r\nContent-Length: 5\r\n\r\nhello",
+		wantErr: ErrTransferEncodingHTTP10,
+	},
+	{
+		name: "TE.TE vertical tab",
+		data: "POST / HTTP/1.1\r\nHost: a\r\nTransfer-Encoding:\vchunked\r\n\r\n",
+		wantErr: headers.ErrInvalidFieldValue,
+	},
+	{
+		name: "differing duplicate Content-Length",
+		data: "POST / HTTP/1.1\r\nHost: a\r\nContent-Length: 5\r\nContent-Length: 10\r\n\r\nhelloSMUGGLED",
+		wantErr: ErrConflictingContentLength,
+	},
+	{
+		name: "differing Content-Length list",
+		data: "POST / HTTP/1.1\r\nHost: a\r\nContent-Length: 5, 10\r\n\r\nhelloSMUGGLED",
+		wantErr: ErrConflictingContentLength,
+	},
+	{
+		name: "signed Content-Length",
+		data: "POST / HTTP/1.1\r\nHost: a\r\nContent-Length: +5\r\n\r\nhello",
+		wantErr: ErrInvalidContentLength,
+	},
+	{
+		name: "negative Content-Length",
+		data: "POST / HTTP/1.1\r\nHost: a\r\nContent-Length: -1\r\n\r\n",
+		wantErr: ErrInvalidContentLength,
+	},
+	{
+		name: "hex Content-Length",
+		data: "POST / HTTP/1.1\r\nHost: a\r\nContent-Length: 0x5\r\n\r\nhello",
+		wantErr: ErrInvalidContentLength,
+	},
+	{
+		name: "Content-Length with inner space",
+		data: "POST / HTTP/1.1\r\nHost: a\r\nContent-Length: 1 0\r\n\r\nhellohello",
+		wantErr: ErrInvalidContentLength,
+	},
+	{
+		name: "empty Content-Length",
+		data: "POST / HTTP/1.1\r\nHost: a\r\nContent-Length: \r\n\r\n",
+		wantErr: ErrInvalidContentLength,
+	},
+	{
+		name: "overflowing Content-Length",
+		data: "POST / HTTP/1.1\r\nHost: a\r\nContent-Length: 99999999999999999999\r\n\r\n",
+		wantErr: ErrInvalidContentLength,
+	},
+	{
+		name: "bare LF after request line",
+		data: "GET / HTTP/1.1\nHost: a\r\n\r\n",
+		wantErr: ErrBareLF,
+	},
+	{
+		name: "bare LF between headers",
+		data: "POST / HTTP/1.1\r\nHost: a\nTransfer-Encoding: chunked\r\n\r\n",
+		wantErr: ErrBareLF,
+	},
+	{
+		name: "bare LF ending headers",
+		data: "GET / HTTP/1.1\r\nHost: a\r\n\n",
+		wantErr: ErrBareLF,
+	},
+	{
+		name: "hex prefix chunk size",
+		data: "POST / HTTP/1.1\r\nHost: a\r\nTransfer-Encoding: chunked\r\n\r\n0x5\r\nhello\r\n0\r\n\r\n",
+		wantErr: ErrInvalidChunkSize,
+	},
+	{
+		name: "signed chunk size",
+		data: "POST / HTTP/1.1\r\nHost: a\r\nTransfer-Encoding: chunked\r\n\r\n+5\r\nhello\r\n0\r\n\r\n",
+		wantErr: ErrInvalidChunkSize,
+	},
+	{
+		name: "chunk size with leading space",
+		data: "POST / HTTP/1.1\r\nHost: a\r\nTransfer-Encoding: chunked\r\n\r\n 5\r\nhello\r\n0\r\n\r\n",
+		wantErr: ErrInvalidChunkSize,
+	},
+	{
+		name: "chunk size with trailing space",
+		data: "POST / HTTP/1.1\r\nHost: a\r\nTransfer-Encoding: chunked\r\n\r\n5 \r\nhello\r\n0\r\n\r\n",
+		wantErr: ErrInvalidChunkSize,
+	},
+	{
+		name: "overflowing chunk size",
+		data: "POST / HTTP/1.1\r\nHost: a\r\nTransfer-Encoding: chunked\r\n\r\nffffffffffffffffff\r\nhello\r\n0\r\n\r\n",
+		wantErr: ErrInvalidChunkSize,
+	},
+	{
+		name: "chunk longer than its size",
+		data: "POST / HTTP/1.1\r\nHost: a\r\nTransfer-Encoding: chunked\r\n\r\n3\r\nhello\r\n0\r\n\r\n",
+		wantErr: ErrInvalidChunkSize,
+	},
+	{
+		name: "bare LF after chunk size",
+		data: "POST / HTTP/1.1\r\nHost: a\r\nTransfer-Encoding: chunked\r\n\r\n5\nhello\r\n0\r\n\r\n",
+		wantErr: ErrBareLF,
+	},
+	{
+		name: "bare LF after chunk data",
+		data: "POST / HTTP/1.1\r\nHost: a\r\nTransfer-Encoding: chunked\r\n\r\n5\r\nhello\n0\r\n\r\n",
+		wantErr: ErrBareLF,
+	},
+	{
+		name: "bare LF in trailers",
+		data: "POST / HTTP/1.1\r\nHost: a\r\nTransfer-Encoding: chunked\r\n\r\n0\r\nX-Trailer: a\n\r\n",
+		wantErr: ErrBareLF,
+	},
+}
+
+func TestSmugglingCorpus (t *testing.T) {
+	for _, tc := range smugglingPayloads {
+		t.Run(tc.name, func(t *testing.T) {
+			r, err := RequestFromReader(strings.NewReader(tc.data))
+			if err == nil {
+				_, err = io.ReadAll(r.Body)
+			}
+			require.ErrorIs(t, err, tc.wantErr)
+		})
+	}
+}
+
+func TestSmugglingAccepted (t *testing.T) {
+	// Test: Identical duplicate Content-Length values
+	r, err := RequestFromReader(strings.NewReader("POST / HTTP/1.1\r\nHost: a\r\nContent-Length: 5\r\nContent-Length: 5\r\n\r\nhello"))
+	require.NoError(t, err)
+	assert.Equal(t, "hello", readBody(t, r))
+
+	// Test: Identical Content-Length list
+	r, err = RequestFromReader(strings.NewReader("POST / HTTP/1.1\r\nHost: a\r\nContent-Length: 5, 5\r\n\r\nhello"))
+	require.NoError(t, err)
+	assert.Equal(t, "hello", readBody(t, r))
+
+	// Test: Chunk extension with whitespace before the semicolon
+	r, err = RequestFromReader(strings.NewReader("POST / HTTP/1.1\r\nHost: a\r\nTransfer-Encoding: Chunked\r\n\r\n5 ;ext\r\nhello\r\n0\r\n\r\n"))
+	require.NoError(t, err)
+	assert.Equal(t, "hello", readBody(t, r))
+}
@@ -0,0 +1,180 @@
+package request
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+)
+
+// TargetForm is one of the four request-target forms of RFC 9112 section 3.2.
+type TargetForm int
+
+const (
+	TargetFormOrigin    TargetForm = iota // "/where?q=now"
+	TargetFormAbsolute                    // "http://www.example.org/pub/WWW/TheProject.html"
+	TargetFormAuthority                   // "www.example.com:80", CONNECT only
+	TargetFormAsterisk                    // "*", OPTIONS only
+)
+
+// Target is the parsed request-target. Path is percent-decoded; RawPath
+// keeps the original encoding for callers that need to split on "/" before
+// decoding.
+type Target struct {
+	Form      TargetForm
+	Scheme    string // absolute-form only
+	Authority string // absolute-form and authority-form
+	Path      string
+	RawPath   string
+	RawQuery  string
+	Query     map[string][]string
+	// Fragment should never be sent by a client, but some do; it is kept
+	// rather than rejected.
+	Fragment string
+}
+
+const (
+	unreservedChars = "-._~"
+	subDelimChars   = "!$&'()*+,;="
+)
+
+func parseTarget(method, raw string) (Target, error) {
+	switch {
+	case raw == "*":
+		if method != "OPTIONS" {
+			return Target{}, fmt.Errorf("%w: asterisk-form is only allowed for OPTIONS", ErrInvalidTarget)
+		}
+		return Target{Form: TargetFormAsterisk, Query: map[string][]string{}}, nil
+	case method == "CONNECT":
+		if !validAuthority(raw) || !strings.Contains(raw, ":") {
+			return Target{}, fmt.Errorf("%w: bad authority-form %s", ErrInvalidTarget, raw)
+		}
+		return Target{Form: TargetFormAuthority, Authority: raw, Query: map[string][]string{}}, nil
+	case strings.HasPrefix(raw, "/"):
+		t, err := parsePathQuery(raw)
+		t.Form = TargetFormOrigin
+		return t, err
+	}
+
+	scheme, rest, ok := strings.Cut(raw, "://")
+	if !ok || !validScheme(scheme) {
+		return Target{}, fmt.Errorf("%w: %s", ErrInvalidTarget, raw)
+	}
+	authorityEnd := strings.IndexAny(rest, "/?#")
+	if authorityEnd == -1 {
+		authorityEnd = len(rest)
+	}
+	authority := rest[:authorityEnd]
+	if authority == "" || !validAuthority(authority) {
+		return Target{}, fmt.Errorf("%w: bad authority in %s", ErrInvalidTarget, raw)
+	}
+	pathQuery := rest[authorityEnd:]
+	if !strings.HasPrefix(pathQuery, "/") {
+		pathQuery = "/" + pathQuery
+	}
+
+	t, err := parsePathQuery(pathQuery)
+	t.Form = TargetFormAbsolute
+	t.Scheme = strings.ToLower(scheme)
+	t.Authority = authority
+	return t, err
+}
+
+// parsePathQuery splits "/path?query#fragment" and validates each part.
+func parsePathQuery(raw string) (Target, error) {
+	var t Target
+	raw, t.Fragment, _ = strings.Cut(raw, "#")
+	t.RawPath, t.RawQuery, _ = strings.Cut(raw, "?")
+
+	if !validPercentEncoded(t.RawPath, ":@/") {
+		return Target{}, fmt.Errorf("%w: invalid character in path %s", ErrInvalidTarget, t.RawPath)
+	}
+	if !validPercentEncoded(t.RawQuery, ":@/?") {
+		return Target{}, fmt.Errorf("%w: invalid character in query %s", ErrInvalidTarget, t.RawQuery)
+	}
+	if !validPercentEncoded(t.Fragment, ":@/?") {
+		return Target{}, fmt.Errorf("%w: invalid character in fragment %s", ErrInvalidTarget, t.Fragment)
+	}
+
+	path, err := url.PathUnescape(t.RawPath)
+	if err != nil {
+		return Target{}, fmt.Errorf("%w: invalid percent-encoding in path %s", ErrInvalidTarget, t.RawPath)
+	}
+	t.Path = path
+
+	t.Query, err = parseQuery(t.RawQuery)
+	if err != nil {
+		return Target{}, err
+	}
+	return t, nil
+}
+
+// parseQuery decodes "a=1&b=2&a=3", keeping every value of a repeated key
+// in order. A key without "=" gets an empty value.
+func parseQuery(rawQuery string) (map[string][]string, error) {
+	query := map[string][]string{}
+	for _, pair := range strings.Split(rawQuery, "&") {
+		if pair == "" {
+			continue
+		}
+		rawKey, rawValue, _ := strings.Cut(pair, "=")
+		key, err := url.QueryUnescape(rawKey)
+		if err != nil {
+			return nil, fmt.Errorf("%w: invalid percent-encoding in query key %s", ErrInvalidTarget, rawKey)
+		}
+		value, err := url.QueryUnescape(rawValue)
+		if err != nil {
+			return nil, fmt.Errorf("%w: invalid percent-encoding in query value %s", ErrInvalidTarget, rawValue)
+		}
+		query[key] = append(query[key], value)
+	}
+	return query, nil
+}
+
+// validPercentEncoded checks s against RFC 3986 pchar-style grammar:
+// unreserved, sub-delims, pct-encoded, and the extra characters allowed.
+func validPercentEncoded(s string, extra string) bool {
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case c == '%':
+			if i+2 >= len(s) || !isHexDigit(s[i+1]) || !isHexDigit(s[i+2]) {
+				return false
+			}
+			i += 2
+		case isAlphaNum(c), strings.IndexByte(unreservedChars, c) != -1,
+			strings.IndexByte(subDelimChars, c) != -1, strings.IndexByte(extra, c) != -1:
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// validAuthority accepts host[:port], including bracketed IPv6 literals.
+// Userinfo is deprecated in http(s) URIs and rejected.
+func validAuthority(s string) bool {
+	if s == "" || strings.Contains(s, "@") {
+		return false
+	}
+	return validPercentEncoded(s, ":[]")
+}
+
+func validScheme(s string) bool {
+	if s == "" || !isAlpha(s[0]) {
+		return false
+	}
+	for i := 1; i < len(s); i++ {
+		if !isAlphaNum(s[i]) && s[i] != '+' && s[i] != '-' && s[i] != '.' {
+			return false
+		}
+	}
+	return true
+}
+
+func isAlpha(c byte) bool {
+	return ('a' <= c && c <= 'z') || ('A' <= c && c <= 'Z')
+}
+
+func isAlphaNum(c byte) bool {
+	return isAlpha(c) || ('0' <= c && c <= '9')
+}
@@ -0,0 +1,90 @@
+package request
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func requestWithTarget(method, target string) (*Request, error) {
+	return RequestFromReader(strings.NewReader(method + " " + target + " HTTP/1.1\r\nHost: localhost:42069\r\n\r\n"))
+}
+
+func TestRequestTargetParse (t *testing.T) {
+	// Test: Origin-form with path and query
+	r, err := requestWithTarget("GET", "/search/caf%C3%A9?q=go+lang&tag=a&tag=b&empty")
+	require.NoError(t, err)
+	target := r.RequestLine.Target
+	assert.Equal(t, TargetFormOrigin, target.Form)
+	assert.Equal(t, "/search/café", target.Path)
+	assert.Equal(t, "/search/caf%C3%A9", target.RawPath)
+	assert.Equal(t, "q=go+lang&tag=a&tag=b&empty", target.RawQuery)
+	assert.Equal(t, []string{"go lang"}, target.Query["q"])
+	assert.Equal(t, []string{"a", "b"}, target.Query["tag"])
+	assert.Equal(t, []string{""}, target.Query["empty"])
+
+	// Test: Fragment is split off
+	r, err = requestWithTarget("GET", "/page?x=1#section")
+	require.NoError(t, err)
+	assert.Equal(t, "/page", r.RequestLine.Target.Path)
+	assert.Equal(t, "x=1", r.RequestLine.Target.RawQuery)
+	assert.Equal(t, "section", r.RequestLine.Target.Fragment)
+
+	// Test: Absolute-form
+	r, err = requestWithTarget("GET", "http://example.com:8080/pub/file.html?a=1")
+	require.NoError(t, err)
+	target = r.RequestLine.Target
+	assert.Equal(t, TargetFormAbsolute, target.Form)
+	assert.Equal(t, "http", target.Scheme)
+	assert.Equal(t, "example.com:8080", target.Authority)
+	assert.Equal(t, "/pub/file.html", target.Path)
+	assert.Equal(t, []string{"1"}, target.Query["a"])
+
+	// Test: Absolute-form with empty path
+	r, err = requestWithTarget("GET", "http://example.com")
+	require.NoError(t, err)
+	assert.Equal(t, "/", r.RequestLine.Target.Path)
+
+	// Test: Authority-form for CONNECT
+	r, err = requestWithTarget("CONNECT", "example.com:443")
+	require.NoError(t, err)
+	assert.Equal(t, TargetFormAuthority, r.RequestLine.Target.Form)
+	assert.Equal(t, "example.com:443", r.RequestLine.Target.Authority)
+
+	// Test: Asterisk-form for OPTIONS
+	r, err = requestWithTarget("OPTIONS", "*")
+	require.NoError(t, err)
+	assert.Equal(t, TargetFormAsterisk, r.RequestLine.Target.Form)
+
+	// Test: Asterisk-form with another method
+	_, err = requestWithTarget("GET", "*")
+	require.Error(t, err)
+
+	// Test: CONNECT without a port
+	_, err = requestWithTarget("CONNECT", "example.com")
+	require.Error(t, err)
+
+	// Test: Invalid characters
+	_, err = requestWithTarget("GET", "/a\"b")
+	require.Error(t, err)
+	_, err = requestWithTarget("GET", "/a<b>")
+	require.Error(t, err)
+	_, err = requestWithTarget("GET", "/a?b=c|d")
+	require.Error(t, err)
+
+	// Test: Bad percent-encoding
+	_, err = requestWithTarget("GET", "/a%2")
+	require.Error(t, err)
+	_, err = requestWithTarget("GET", "/a%zz")
+	require.Error(t, err)
+
+	// Test: Neither a path nor an absolute URI
+	_, err = requestWithTarget("GET", "coffee")
+	require.Error(t, err)
+
+	// Test: Userinfo in absolute-form
+	_, err = requestWithTarget("GET", "http://user@example.com/")
+	require.Error(t, err)
+}
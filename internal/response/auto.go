@@ -0,0 +1,167 @@
+package response
+
+import (
+	"errors"
+	"fmt"
+	"httpfromtcp/internal/headers"
+)
+
+// Header, WriteHeader, Write and Flush let a handler write a response
+// without framing it: the writer buffers up to autoBufferSize bytes and, if
+// the handler returns within that, sends them with a Content-Length. A
+// larger or flushed body is sent chunked instead, unless the handler set a
+// Content-Length itself. The server calls Finish once the handler returns.
+//
+// A handler should use either these methods or WriteStatusLine and the
+// other low-level ones, not both.
+
+const autoBufferSize = 4 << 10
+
+// ErrBodyNotAllowed is returned by Write for status codes that cannot have a
+// body, such as 204 and 304.
+var ErrBodyNotAllowed = errors.New("response status does not allow a body")
+
+var errAborted = errors.New("response aborted")
+
+// SetMethod tells the writer the request method, so that the body of a
+// response to HEAD can be suppressed. It must be called before the handler
+// writes anything.
+func (w *Writer) SetMethod(method string) {
+	w.head = method == "HEAD"
+}
+
+// Header returns the fields to send with the response. Changes after the
+// first Flush, or after Write has had to start sending, have no effect.
+func (w *Writer) Header() *headers.Headers {
+	return &w.header
+}
+
+// WriteHeader sets the status code of the response; only the first call
+// counts. A 1xx code is sent straight away as an interim response with the
+// current Header.
+func (w *Writer) WriteHeader(statusCode StatusCode) {
+	if statusCode >= 100 && statusCode < 200 {
+		w.WriteInformational(statusCode, w.header)
+		return
+	}
+	if w.autoStatus == 0 {
+		w.autoStatus = statusCode
+	}
+}
+
+func (w *Writer) Write(p []byte) (int, error) {
+	w.WriteHeader(StatusCodeSuccess)
+	if !bodyAllowed(w.autoStatus) {
+		return 0, ErrBodyNotAllowed
+	}
+	if w.head {
+		w.headLen += int64(len(p))
+		return len(p), nil
+	}
+	if len(p) == 0 {
+		return 0, nil
+	}
+	if !w.committed {
+		if w.writerState != writerStateStatusLine {
+			return 0, fmt.Errorf("write after WriteStatusLine")
+		}
+		if len(w.buf)+len(p) <= autoBufferSize {
+			w.buf = append(w.buf, p...)
+			return len(p), nil
+		}
+		if err := w.Flush(); err != nil {
+			return 0, err
+		}
+	}
+	if w.chunked || w.unchunked {
+		if _, err := w.WriteChunkedBody(p); err != nil {
+			return 0, err
+		}
+		return len(p), nil
+	}
+	n, err := w.writer.Write(p)
+	w.bodyBytes += int64(n)
+	return n, err
+}
+
+// Flush sends the status line, the headers and whatever has been buffered,
+// committing the response to chunked framing unless it has a
+// Content-Length or no body.
+func (w *Writer) Flush() error {
+	if !w.committed {
+		if err := w.commit(false); err != nil {
+			return err
+		}
+	}
+	if len(w.buf) == 0 {
+		return nil
+	}
+	buf := w.buf
+	w.buf = nil
+	if w.chunked || w.unchunked {
+		_, err := w.WriteChunkedBody(buf)
+		return err
+	}
+	n, err := w.writer.Write(buf)
+	w.bodyBytes += int64(n)
+	return err
+}
+
+// Finish completes a response written with Write: a buffered one is sent
+// with its Content-Length, a chunked one is terminated. A handler that
+// wrote nothing gets an empty 200, or whatever status it set. Finish does
+// nothing if the handler used the low-level methods, and fails if it
+// aborted the response.
+func (w *Writer) Finish() error {
+	if w.aborted {
+		return errAborted
+	}
+	if !w.committed {
+		if w.writerState != writerStateStatusLine {
+			return nil
+		}
+		if err := w.commit(true); err != nil {
+			return err
+		}
+		return w.Flush()
+	}
+	if w.writerState == writerStateBody && (w.chunked || w.unchunked) && !w.head {
+		if _, err := w.WriteChunkedBodyDone(); err != nil {
+			return err
+		}
+		return w.WriteTrailers(headers.NewHeaders())
+	}
+	return nil
+}
+
+// commit writes the status line and headers. When final is set the whole
+// body is in the buffer, so its length is known.
+func (w *Writer) commit(final bool) error {
+	w.WriteHeader(StatusCodeSuccess)
+	w.committed = true
+	if err := w.WriteStatusLine(w.autoStatus); err != nil {
+		return err
+	}
+
+	h := headers.NewHeaders()
+	for _, f := range w.header.Fields() {
+		h.Add(f.Name, f.Value)
+	}
+	_, hasLength := h.Get("Content-Length")
+	switch {
+	case !bodyAllowed(w.autoStatus):
+		// RFC 9110 section 8.6
+		if w.autoStatus == StatusCodeNoContent {
+			h.Del("Content-Length")
+		}
+		h.Del("Transfer-Encoding")
+	case hasLength:
+	case final && w.head:
+		h.Set("Content-Length", fmt.Sprintf("%d", w.headLen))
+	case final:
+		h.Set("Content-Length", fmt.Sprintf("%d", len(w.buf)))
+	case !w.head:
+		h.Set("Transfer-Encoding", "chunked")
+	}
+	return w.WriteHeaders(h)
+}
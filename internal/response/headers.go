@@ -5,11 +5,13 @@ import (
 	"httpfromtcp/internal/headers"
 )
 
+// GetDefaultHeaders returns Content-Length and a text/plain Content-Type.
+// Connection is added by the Writer, depending on whether the connection
+// is kept open.
 func GetDefaultHeaders(contentLen int) headers.Headers {
 	h := headers.NewHeaders()
 
 	h.Set("Content-Length", fmt.Sprintf("%d", contentLen))
-	h.Set("Connection", "close")
 	h.Set("Content-Type", "text/plain")
 	return h
-}
\ No newline at end of file
+}
@@ -4,11 +4,31 @@ import (
 	"fmt"
 	"httpfromtcp/internal/headers"
 	"io"
+	"strings"
 )
 
 type Writer struct {
 	writerState writerState
 	writer io.Writer
+	version string
+	statusCode StatusCode
+	keepAlive bool
+	chunked bool
+	// unchunked is set when the handler asked for a chunked response but the
+	// client cannot decode one: chunks are written bare and the connection
+	// is closed to mark the end of the body.
+	unchunked bool
+	head bool
+
+	// State of Write and friends; see auto.go
+	header headers.Headers
+	autoStatus StatusCode
+	buf []byte
+	committed bool
+	headLen int64
+
+	bodyBytes int64
+	aborted bool
 }
 
 type writerState int
@@ -18,37 +38,147 @@ const (
 	writerStateHeaders
 	writerStateBody
 	writerStateTrailers
+	writerStateDone
 )
 
 func NewWriter(w io.Writer) *Writer {
     return &Writer{
         writerState: writerStateStatusLine,
 		writer: w,
+		version: "1.1",
     }
 }
 
+// SetVersion sets the HTTP version of the response, "1.1" by default, to
+// that of the request. HTTP/1.0 clients get no interim responses and no
+// chunked framing. It must be called before WriteStatusLine.
+func (w *Writer) SetVersion(version string) {
+	w.version = version
+}
+
+// SetKeepAlive tells the writer whether the server wants to reuse the
+// connection after this response. It must be called before WriteHeaders.
+func (w *Writer) SetKeepAlive(keepAlive bool) {
+	w.keepAlive = keepAlive
+}
+
+// KeepAlive reports whether the connection can be reused once the handler
+// has returned: the server must want it, the handler must not have sent
+// "Connection: close", and the response must have been written completely.
+func (w *Writer) KeepAlive() bool {
+	if !w.keepAlive || w.aborted {
+		return false
+	}
+	if w.chunked || w.unchunked {
+		return w.writerState == writerStateDone
+	}
+	return w.writerState >= writerStateBody
+}
+
 func (w *Writer) WriteStatusLine(statusCode StatusCode) error {
+	return w.WriteStatusLineWithReason(statusCode, StatusText(statusCode))
+}
+
+// WriteStatusLineWithReason writes the final status line with a custom
+// reason phrase in place of the standard one.
+func (w *Writer) WriteStatusLineWithReason(statusCode StatusCode, reasonPhrase string) error {
 	if w.writerState != writerStateStatusLine {
 		return fmt.Errorf("incorrect order for writing status")
 	}
+	if statusCode < 200 || statusCode > 999 {
+		return fmt.Errorf("invalid final status code: %d", statusCode)
+	}
+	if !validReasonPhrase(reasonPhrase) {
+		return fmt.Errorf("invalid reason phrase: %q", reasonPhrase)
+	}
 	defer func() {w.writerState = writerStateHeaders }()
-	_, err := w.writer.Write(getStatusLine(statusCode))
+	w.statusCode = statusCode
+	_, err := w.writer.Write(getStatusLine(w.version, statusCode, reasonPhrase))
 	return err
 }
 
-func (w *Writer) WriteHeaders(headers headers.Headers) error {
+// WriteInformational sends a 1xx interim response such as 100 Continue or
+// 103 Early Hints. Any number of them may precede the final status line.
+// 101 Switching Protocols is not supported since it ends HTTP/1.1 on the
+// connection.
+func (w *Writer) WriteInformational(statusCode StatusCode, h headers.Headers) error {
+	if w.writerState != writerStateStatusLine {
+		return fmt.Errorf("informational response after the final status line")
+	}
+	if statusCode < 100 || statusCode > 199 || statusCode == StatusCodeSwitchingProtocols {
+		return fmt.Errorf("invalid informational status code: %d", statusCode)
+	}
+	// RFC 9110 section 15.2
+	if w.version == "1.0" {
+		return fmt.Errorf("informational responses cannot be sent to HTTP/1.0 clients")
+	}
+	if err := validateFields(h); err != nil {
+		return err
+	}
+	if _, err := w.writer.Write(getStatusLine(w.version, statusCode, StatusText(statusCode))); err != nil {
+		return err
+	}
+	for _, f := range h.Fields() {
+		if _, err := fmt.Fprintf(w.writer, "%s: %s\r\n", headers.CanonicalKey(f.Name), f.Value); err != nil {
+			return err
+		}
+	}
+	_, err := w.writer.Write([]byte("\r\n"))
+	return err
+}
+
+func (w *Writer) WriteHeaders(h headers.Headers) error {
 	if w.writerState != writerStateHeaders {
 		return fmt.Errorf("incorrect order for writing headers")
 	}
+	// Fields set through Header, by middleware say, unless h overrides them.
+	// h is the caller's, so add them to a copy
+	h = h.Clone()
+	for _, f := range w.header.Fields() {
+		if _, ok := h.Get(f.Name); !ok {
+			h.Add(f.Name, f.Value)
+		}
+	}
+	if err := validateFields(h); err != nil {
+		return err
+	}
 	defer func() { w.writerState = writerStateBody }()
-	for key, value := range headers {
-		message := fmt.Sprintf("%s: %s\r\n", key, value)
+
+	if h.HasToken("Connection", "close") {
+		w.keepAlive = false
+	}
+	if h.HasToken("Transfer-Encoding", "chunked") {
+		if w.version == "1.0" {
+			w.unchunked = true
+		} else {
+			w.chunked = true
+		}
+	}
+	// Without a length the body can only end with the connection
+	_, hasLength := h.Get("Content-Length")
+	if w.unchunked || (!w.chunked && !hasLength && !w.head && bodyAllowed(w.statusCode)) {
+		w.keepAlive = false
+	}
+
+	for _, f := range h.Fields() {
+		if strings.EqualFold(f.Name, "Connection") {
+			continue
+		}
+		if w.unchunked && (strings.EqualFold(f.Name, "Transfer-Encoding") || strings.EqualFold(f.Name, "Trailer")) {
+			continue
+		}
+		message := fmt.Sprintf("%s: %s\r\n", headers.CanonicalKey(f.Name), f.Value)
 		_, err := w.writer.Write([]byte(message))
 		if err != nil {
 			return fmt.Errorf("error writing headers: %s", err.Error())
 		}
 	}
-	_, err := w.writer.Write([]byte("\r\n"))
+
+	connection := "close"
+	if w.keepAlive {
+		connection = "keep-alive"
+	}
+	_, err := fmt.Fprintf(w.writer, "Connection: %s\r\n\r\n", connection)
 	return err
 }
 
@@ -57,14 +187,33 @@ func (w *Writer) WriteBody(p []byte) (int, error) {
 		return 0, fmt.Errorf("incorrect order for writing body")
 	}
 	defer func() {w.writerState = writerStateTrailers}()
-	return w.writer.Write(p)
+	if w.bodySuppressed() {
+		return len(p), nil
+	}
+	n, err := w.writer.Write(p)
+	w.bodyBytes += int64(n)
+	return n, err
+}
+
+// bodySuppressed reports whether the body must not be sent at all: the
+// response is to a HEAD request, or its status does not allow one. The
+// body-writing methods then drop what they are given, framing included.
+func (w *Writer) bodySuppressed() bool {
+	return w.head || !bodyAllowed(w.statusCode)
 }
 
-// TODO: Write the below functions, concatenate the hex part with the written data
 func (w *Writer) WriteChunkedBody(p []byte) (int, error) {
     if w.writerState != writerStateBody {
         return 0, fmt.Errorf("incorrect order for writing body")
     }
+	if w.bodySuppressed() {
+		return len(p), nil
+	}
+	if w.unchunked {
+		n, err := w.writer.Write(p)
+		w.bodyBytes += int64(n)
+		return n, err
+	}
 	chunkSize := len(p)
 
 	nTotal := 0
@@ -75,6 +224,7 @@ func (w *Writer) WriteChunkedBody(p []byte) (int, error) {
 	nTotal += n
 
     n, err = w.writer.Write(p)
+	w.bodyBytes += int64(n)
     if err != nil {
         return nTotal, err
     }
@@ -89,17 +239,27 @@ func (w *Writer) WriteChunkedBody(p []byte) (int, error) {
 }
 func (w *Writer) WriteChunkedBodyDone() (int, error) {
 	defer func() {w.writerState = writerStateTrailers}()
+	if w.unchunked || w.bodySuppressed() {
+		return 0, nil
+	}
 	return w.writer.Write([]byte("0\r\n"))
 }
 
 func (w *Writer) WriteTrailers(h headers.Headers) error {
 	if w.writerState != writerStateTrailers {
-		fmt.Println("returning error")
 		return fmt.Errorf("writing trailers out of order: %v", w.writerState)
 	}
-	for key, value := range h {
-		message := fmt.Sprintf("%s: %s\r\n", key, value)
-		fmt.Printf("message is: %s", message)
+	if err := validateFields(h); err != nil {
+		return err
+	}
+	defer func() { w.writerState = writerStateDone }()
+	// There is nowhere to put trailers without chunked framing, and no
+	// trailer section without a body
+	if w.unchunked || w.bodySuppressed() {
+		return nil
+	}
+	for _, f := range h.Fields() {
+		message := fmt.Sprintf("%s: %s\r\n", headers.CanonicalKey(f.Name), f.Value)
 		_, err := w.writer.Write([]byte(message))
 		if err != nil {
 			return fmt.Errorf("error writing headers: %s", err.Error())
@@ -108,4 +268,72 @@ func (w *Writer) WriteTrailers(h headers.Headers) error {
 
 	_, err := w.writer.Write([]byte("\r\n"))
 	return err
-}
\ No newline at end of file
+}
+
+// Status returns the status code of the response: the one sent, or else
+// the one Finish will send.
+func (w *Writer) Status() StatusCode {
+	switch {
+	case w.statusCode != 0:
+		return w.statusCode
+	case w.autoStatus != 0:
+		return w.autoStatus
+	default:
+		return StatusCodeSuccess
+	}
+}
+
+// BytesWritten returns the number of body bytes the handler has written,
+// including any still buffered by Write but not those suppressed for HEAD.
+func (w *Writer) BytesWritten() int64 {
+	return w.bodyBytes + int64(len(w.buf))
+}
+
+// Committed reports whether the status line has been sent, after which the
+// response can no longer be replaced.
+func (w *Writer) Committed() bool {
+	return w.writerState != writerStateStatusLine
+}
+
+// Reset discards the status, header fields and body buffered so far, so a
+// different response can be written instead. It reports false, and does
+// nothing, once the response is committed.
+func (w *Writer) Reset() bool {
+	if w.Committed() {
+		return false
+	}
+	w.header = headers.NewHeaders()
+	w.autoStatus = 0
+	w.buf = nil
+	w.headLen = 0
+	return true
+}
+
+// Abort marks the response as broken off, for when the handler failed
+// after part of it was sent. Nothing more is written, and the server
+// resets the connection so the client cannot take what it received for a
+// complete response.
+func (w *Writer) Abort() {
+	w.aborted = true
+}
+
+func (w *Writer) Aborted() bool {
+	return w.aborted
+}
+
+// bodyAllowed reports whether a response with statusCode can have a body;
+// 1xx, 204 and 304 responses never do (RFC 9112 section 6.3).
+func bodyAllowed(statusCode StatusCode) bool {
+	return statusCode >= 200 && statusCode != StatusCodeNoContent && statusCode != StatusCodeNotModified
+}
+
+// validateFields refuses values that would let an echoed string inject
+// extra header lines into the response.
+func validateFields(h headers.Headers) error {
+	for _, f := range h.Fields() {
+		if !headers.ValidFieldValue(f.Value) {
+			return fmt.Errorf("invalid value for header %s: %q", f.Name, f.Value)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,258 @@
+package response
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"testing"
+
+	"httpfromtcp/internal/headers"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestStatusLine (t *testing.T) {
+	// Test: Standard reason phrases
+	assert.Equal(t, "OK", StatusText(StatusCodeSuccess))
+	assert.Equal(t, "HTTP Version Not Supported", StatusText(StatusCodeHTTPVersionNotSupported))
+	assert.Equal(t, "", StatusText(299))
+
+	// Test: Registered code
+	var buf bytes.Buffer
+	w := NewWriter(&buf)
+	require.NoError(t, w.WriteStatusLine(StatusCodeNotFound))
+	assert.Equal(t, "HTTP/1.1 404 Not Found\r\n", buf.String())
+
+	// Test: Unregistered code has an empty reason phrase
+	buf.Reset()
+	w = NewWriter(&buf)
+	require.NoError(t, w.WriteStatusLine(299))
+	assert.Equal(t, "HTTP/1.1 299 \r\n", buf.String())
+
+	// Test: Custom reason phrase
+	buf.Reset()
+	w = NewWriter(&buf)
+	require.NoError(t, w.WriteStatusLineWithReason(StatusCodeSuccess, "Totally Fine"))
+	assert.Equal(t, "HTTP/1.1 200 Totally Fine\r\n", buf.String())
+
+	// Test: Reason phrase with CRLF is rejected
+	buf.Reset()
+	w = NewWriter(&buf)
+	require.Error(t, w.WriteStatusLineWithReason(StatusCodeSuccess, "OK\r\nSet-Cookie: x=y"))
+	assert.Empty(t, buf.String())
+
+	// Test: Informational code as final status is rejected
+	require.Error(t, w.WriteStatusLine(StatusCodeContinue))
+}
+
+func TestWriteInformational (t *testing.T) {
+	// Test: 100 Continue and 103 Early Hints before the final response
+	var buf bytes.Buffer
+	w := NewWriter(&buf)
+	require.NoError(t, w.WriteInformational(StatusCodeContinue, headers.NewHeaders()))
+	hints := headers.NewHeaders()
+	hints.Set("Link", "</style.css>; rel=preload; as=style")
+	require.NoError(t, w.WriteInformational(StatusCodeEarlyHints, hints))
+	require.NoError(t, w.WriteStatusLine(StatusCodeSuccess))
+	assert.Equal(t, "HTTP/1.1 100 Continue\r\n\r\n"+
+		"HTTP/1.1 103 Early Hints\r\nLink: </style.css>; rel=preload; as=style\r\n\r\n"+
+		"HTTP/1.1 200 OK\r\n", buf.String())
+
+	// Test: Not after the final status line
+	require.Error(t, w.WriteInformational(StatusCodeEarlyHints, headers.NewHeaders()))
+
+	// Test: Non-1xx and 101 are rejected
+	w = NewWriter(&buf)
+	require.Error(t, w.WriteInformational(StatusCodeSuccess, headers.NewHeaders()))
+	require.Error(t, w.WriteInformational(StatusCodeSwitchingProtocols, headers.NewHeaders()))
+}
+
+func TestWriterHTTP10 (t *testing.T) {
+	// Test: Status line uses the request's version
+	var buf bytes.Buffer
+	w := NewWriter(&buf)
+	w.SetVersion("1.0")
+	w.SetKeepAlive(true)
+	require.Error(t, w.WriteInformational(StatusCodeContinue, headers.NewHeaders()))
+	require.NoError(t, w.WriteStatusLine(StatusCodeSuccess))
+	require.NoError(t, w.WriteHeaders(GetDefaultHeaders(2)))
+	_, err := w.WriteBody([]byte("ok"))
+	require.NoError(t, err)
+	assert.Equal(t, "HTTP/1.0 200 OK\r\nContent-Length: 2\r\nContent-Type: text/plain\r\nConnection: keep-alive\r\n\r\nok", buf.String())
+	assert.True(t, w.KeepAlive())
+
+	// Test: Chunked response is sent unframed and closes the connection
+	buf.Reset()
+	w = NewWriter(&buf)
+	w.SetVersion("1.0")
+	w.SetKeepAlive(true)
+	h := headers.NewHeaders()
+	h.Set("Transfer-Encoding", "chunked")
+	h.Set("Trailer", "X-Checksum")
+	require.NoError(t, w.WriteStatusLine(StatusCodeSuccess))
+	require.NoError(t, w.WriteHeaders(h))
+	_, err = w.WriteChunkedBody([]byte("hello "))
+	require.NoError(t, err)
+	_, err = w.WriteChunkedBody([]byte("world"))
+	require.NoError(t, err)
+	_, err = w.WriteChunkedBodyDone()
+	require.NoError(t, err)
+	trailers := headers.NewHeaders()
+	trailers.Set("X-Checksum", "abc")
+	require.NoError(t, w.WriteTrailers(trailers))
+	assert.Equal(t, "HTTP/1.0 200 OK\r\nConnection: close\r\n\r\nhello world", buf.String())
+	assert.False(t, w.KeepAlive())
+}
+
+func TestWriterUndelimitedBody (t *testing.T) {
+	// Test: No Content-Length means the connection must close
+	var buf bytes.Buffer
+	w := NewWriter(&buf)
+	w.SetKeepAlive(true)
+	require.NoError(t, w.WriteStatusLine(StatusCodeSuccess))
+	require.NoError(t, w.WriteHeaders(headers.NewHeaders()))
+	assert.Equal(t, "HTTP/1.1 200 OK\r\nConnection: close\r\n\r\n", buf.String())
+	assert.False(t, w.KeepAlive())
+
+	// Test: 204 has no body to delimit
+	buf.Reset()
+	w = NewWriter(&buf)
+	w.SetKeepAlive(true)
+	require.NoError(t, w.WriteStatusLine(StatusCodeNoContent))
+	require.NoError(t, w.WriteHeaders(headers.NewHeaders()))
+	assert.Equal(t, "HTTP/1.1 204 No Content\r\nConnection: keep-alive\r\n\r\n", buf.String())
+}
+
+func TestWriterAutoFraming (t *testing.T) {
+	// Test: Small body is buffered and sent with Content-Length
+	var buf bytes.Buffer
+	w := NewWriter(&buf)
+	w.SetKeepAlive(true)
+	w.Header().Set("Content-Type", "text/plain")
+	_, err := w.Write([]byte("hello "))
+	require.NoError(t, err)
+	_, err = w.Write([]byte("world"))
+	require.NoError(t, err)
+	assert.Empty(t, buf.String())
+	require.NoError(t, w.Finish())
+	assert.Equal(t, "HTTP/1.1 200 OK\r\nContent-Type: text/plain\r\nContent-Length: 11\r\nConnection: keep-alive\r\n\r\nhello world", buf.String())
+	assert.True(t, w.KeepAlive())
+
+	// Test: Nothing written gives an empty response with the chosen status
+	buf.Reset()
+	w = NewWriter(&buf)
+	w.WriteHeader(StatusCodeCreated)
+	require.NoError(t, w.Finish())
+	assert.Equal(t, "HTTP/1.1 201 Created\r\nContent-Length: 0\r\nConnection: close\r\n\r\n", buf.String())
+
+	// Test: Large body switches to chunked
+	buf.Reset()
+	w = NewWriter(&buf)
+	w.SetKeepAlive(true)
+	large := bytes.Repeat([]byte("a"), autoBufferSize+1)
+	n, err := w.Write(large)
+	require.NoError(t, err)
+	assert.Equal(t, len(large), n)
+	require.NoError(t, w.Finish())
+	assert.Equal(t, fmt.Sprintf("HTTP/1.1 200 OK\r\nTransfer-Encoding: chunked\r\nConnection: keep-alive\r\n\r\n%x\r\n%s\r\n0\r\n\r\n", len(large), large), buf.String())
+	assert.True(t, w.KeepAlive())
+
+	// Test: Flush sends what is buffered as a chunk
+	buf.Reset()
+	w = NewWriter(&buf)
+	w.Write([]byte("event: 1\n"))
+	require.NoError(t, w.Flush())
+	assert.Equal(t, "HTTP/1.1 200 OK\r\nTransfer-Encoding: chunked\r\nConnection: close\r\n\r\n9\r\nevent: 1\n\r\n", buf.String())
+	w.Write([]byte("event: 2\n"))
+	require.NoError(t, w.Finish())
+	assert.True(t, strings.HasSuffix(buf.String(), "9\r\nevent: 2\n\r\n0\r\n\r\n"))
+
+	// Test: Declared Content-Length is streamed as is
+	buf.Reset()
+	w = NewWriter(&buf)
+	w.Header().Set("Content-Length", "5")
+	w.Write([]byte("he"))
+	require.NoError(t, w.Flush())
+	w.Write([]byte("llo"))
+	require.NoError(t, w.Finish())
+	assert.Equal(t, "HTTP/1.1 200 OK\r\nContent-Length: 5\r\nConnection: close\r\n\r\nhello", buf.String())
+
+	// Test: HTTP/1.0 client gets a close-delimited body instead of chunks
+	buf.Reset()
+	w = NewWriter(&buf)
+	w.SetVersion("1.0")
+	w.SetKeepAlive(true)
+	w.Write([]byte("hi"))
+	require.NoError(t, w.Flush())
+	require.NoError(t, w.Finish())
+	assert.Equal(t, "HTTP/1.0 200 OK\r\nConnection: close\r\n\r\nhi", buf.String())
+	assert.False(t, w.KeepAlive())
+}
+
+func TestWriterBodySuppressed (t *testing.T) {
+	// Test: HEAD gets the Content-Length but no body
+	var buf bytes.Buffer
+	w := NewWriter(&buf)
+	w.SetMethod("HEAD")
+	w.SetKeepAlive(true)
+	n, err := w.Write([]byte("hello"))
+	require.NoError(t, err)
+	assert.Equal(t, 5, n)
+	require.NoError(t, w.Finish())
+	assert.Equal(t, "HTTP/1.1 200 OK\r\nContent-Length: 5\r\nConnection: keep-alive\r\n\r\n", buf.String())
+	assert.True(t, w.KeepAlive())
+
+	// Test: Flushed HEAD response is not chunked
+	buf.Reset()
+	w = NewWriter(&buf)
+	w.SetMethod("HEAD")
+	w.SetKeepAlive(true)
+	require.NoError(t, w.Flush())
+	w.Write([]byte("hello"))
+	require.NoError(t, w.Finish())
+	assert.Equal(t, "HTTP/1.1 200 OK\r\nConnection: keep-alive\r\n\r\n", buf.String())
+	assert.True(t, w.KeepAlive())
+
+	// Test: 204 and 304 refuse a body
+	for _, code := range []StatusCode{StatusCodeNoContent, StatusCodeNotModified} {
+		buf.Reset()
+		w = NewWriter(&buf)
+		w.SetKeepAlive(true)
+		w.WriteHeader(code)
+		_, err = w.Write([]byte("hello"))
+		require.ErrorIs(t, err, ErrBodyNotAllowed)
+		require.NoError(t, w.Finish())
+		assert.Equal(t, fmt.Sprintf("HTTP/1.1 %d %s\r\nConnection: keep-alive\r\n\r\n", code, StatusText(code)), buf.String())
+		assert.True(t, w.KeepAlive())
+	}
+}
+
+func TestWriterRecording (t *testing.T) {
+	// Test: Low-level writes are recorded, and Header fields are merged in
+	var buf bytes.Buffer
+	w := NewWriter(&buf)
+	assert.Equal(t, StatusCodeSuccess, w.Status())
+	w.Header().Set("X-Request-Id", "abc")
+	w.Header().Set("Content-Type", "text/html")
+	require.NoError(t, w.WriteStatusLine(StatusCodeNotFound))
+	assert.True(t, w.Committed())
+	assert.False(t, w.Reset())
+	require.NoError(t, w.WriteHeaders(GetDefaultHeaders(5)))
+	w.WriteBody([]byte("hello"))
+	assert.Equal(t, StatusCodeNotFound, w.Status())
+	assert.Equal(t, int64(5), w.BytesWritten())
+	assert.Equal(t, "HTTP/1.1 404 Not Found\r\nContent-Length: 5\r\nContent-Type: text/plain\r\nX-Request-Id: abc\r\nConnection: close\r\n\r\nhello", buf.String())
+
+	// Test: Buffered bytes count before they are sent, and Reset drops them
+	buf.Reset()
+	w = NewWriter(&buf)
+	w.WriteHeader(StatusCodeAccepted)
+	w.Write([]byte("partial"))
+	assert.Equal(t, StatusCodeAccepted, w.Status())
+	assert.Equal(t, int64(7), w.BytesWritten())
+	assert.True(t, w.Reset())
+	assert.Equal(t, int64(0), w.BytesWritten())
+	require.NoError(t, w.Finish())
+	assert.Equal(t, "HTTP/1.1 200 OK\r\nContent-Length: 0\r\nConnection: close\r\n\r\n", buf.String())
+}
@@ -0,0 +1,225 @@
+package router
+
+import (
+	"fmt"
+	"httpfromtcp/internal/request"
+	"httpfromtcp/internal/response"
+	"httpfromtcp/internal/server"
+	"net/url"
+	"sort"
+	"strings"
+)
+
+// Router dispatches requests by method and path pattern. Patterns are split
+// on "/" and each segment is either a literal, a parameter like "{id}" that
+// matches one segment, or a trailing wildcard like "{path...}" that matches
+// the rest of the path. Captured values are available through
+// req.PathValue.
+//
+// When several patterns match, literals beat parameters and parameters beat
+// wildcards, segment by segment, so "/users/me" wins over "/users/{id}".
+// HEAD requests without a route of their own go to the GET route.
+type Router struct {
+	routes []route
+}
+
+type route struct {
+	method   string
+	pattern  string
+	segments []segment
+	handler  server.Handler
+}
+
+type segmentKind int
+
+// Ordered from most to least specific
+const (
+	segmentLiteral segmentKind = iota
+	segmentParam
+	segmentWildcard
+)
+
+type segment struct {
+	kind  segmentKind
+	value string // literal text, or the parameter name
+}
+
+func New() *Router {
+	return &Router{}
+}
+
+// Handle registers handler for method and pattern. It panics on a malformed
+// or duplicate pattern, since that is a programming error.
+func (rt *Router) Handle(method, pattern string, handler server.Handler) {
+	segments, err := parsePattern(pattern)
+	if err != nil {
+		panic(err)
+	}
+	for _, existing := range rt.routes {
+		if existing.method == method && existing.pattern == pattern {
+			panic(fmt.Sprintf("router: duplicate route %s %s", method, pattern))
+		}
+	}
+
+	rt.routes = append(rt.routes, route{
+		method:   method,
+		pattern:  pattern,
+		segments: segments,
+		handler:  handler,
+	})
+	sort.SliceStable(rt.routes, func(i, j int) bool {
+		return moreSpecific(rt.routes[i].segments, rt.routes[j].segments)
+	})
+}
+
+// ServeRequest is a server.Handler.
+func (rt *Router) ServeRequest(w *response.Writer, req *request.Request) {
+	// Split before decoding so an encoded "%2F" stays inside its segment
+	parts := splitPath(req.RequestLine.Target.RawPath)
+	for i, part := range parts {
+		if decoded, err := url.PathUnescape(part); err == nil {
+			parts[i] = decoded
+		}
+	}
+
+	// HEAD is served by the GET route unless it has a route of its own
+	// (RFC 9110 section 9.1); the writer leaves out the body
+	var allowed []string
+	var get *route
+	var getParams map[string]string
+	for i, r := range rt.routes {
+		params, ok := match(r.segments, parts)
+		if !ok {
+			continue
+		}
+		if r.method != req.RequestLine.Method {
+			if r.method == "GET" {
+				allowed = append(allowed, "HEAD")
+				if get == nil && req.RequestLine.Method == "HEAD" {
+					get, getParams = &rt.routes[i], params
+				}
+			}
+			allowed = append(allowed, r.method)
+			continue
+		}
+		serveRoute(w, req, r, params)
+		return
+	}
+	if get != nil {
+		serveRoute(w, req, *get, getParams)
+		return
+	}
+
+	if len(allowed) > 0 {
+		sort.Strings(allowed)
+		writeError(w, response.StatusCodeMethodNotAllowed, strings.Join(dedupe(allowed), ", "))
+		return
+	}
+	writeError(w, response.StatusCodeNotFound, "")
+}
+
+func serveRoute(w *response.Writer, req *request.Request, r route, params map[string]string) {
+	for name, value := range params {
+		req.SetPathValue(name, value)
+	}
+	r.handler(w, req)
+}
+
+func parsePattern(pattern string) ([]segment, error) {
+	if !strings.HasPrefix(pattern, "/") {
+		return nil, fmt.Errorf("router: pattern %q must start with /", pattern)
+	}
+	parts := splitPath(pattern)
+	segments := make([]segment, 0, len(parts))
+	for i, part := range parts {
+		if !strings.HasPrefix(part, "{") || !strings.HasSuffix(part, "}") {
+			if strings.ContainsAny(part, "{}") {
+				return nil, fmt.Errorf("router: malformed segment %q in pattern %q", part, pattern)
+			}
+			segments = append(segments, segment{kind: segmentLiteral, value: part})
+			continue
+		}
+
+		name := part[1 : len(part)-1]
+		kind := segmentParam
+		if strings.HasSuffix(name, "...") {
+			if i != len(parts)-1 {
+				return nil, fmt.Errorf("router: wildcard must be the last segment in pattern %q", pattern)
+			}
+			name = strings.TrimSuffix(name, "...")
+			kind = segmentWildcard
+		}
+		if name == "" {
+			return nil, fmt.Errorf("router: unnamed parameter in pattern %q", pattern)
+		}
+		segments = append(segments, segment{kind: kind, value: name})
+	}
+	return segments, nil
+}
+
+// splitPath turns "/a/b/" into ["a", "b", ""]; the root path is no segments.
+func splitPath(path string) []string {
+	path = strings.TrimPrefix(path, "/")
+	if path == "" {
+		return nil
+	}
+	return strings.Split(path, "/")
+}
+
+func match(segments []segment, parts []string) (map[string]string, bool) {
+	params := map[string]string{}
+	for i, seg := range segments {
+		if seg.kind == segmentWildcard {
+			params[seg.value] = strings.Join(parts[min(i, len(parts)):], "/")
+			return params, true
+		}
+		if i >= len(parts) {
+			return nil, false
+		}
+		switch seg.kind {
+		case segmentLiteral:
+			if parts[i] != seg.value {
+				return nil, false
+			}
+		case segmentParam:
+			if parts[i] == "" {
+				return nil, false
+			}
+			params[seg.value] = parts[i]
+		}
+	}
+	if len(parts) != len(segments) {
+		return nil, false
+	}
+	return params, true
+}
+
+func moreSpecific(a, b []segment) bool {
+	for i := 0; i < len(a) && i < len(b); i++ {
+		if a[i].kind != b[i].kind {
+			return a[i].kind < b[i].kind
+		}
+	}
+	return len(a) > len(b)
+}
+
+func dedupe(sorted []string) []string {
+	out := sorted[:0]
+	for i, s := range sorted {
+		if i == 0 || s != sorted[i-1] {
+			out = append(out, s)
+		}
+	}
+	return out
+}
+
+func writeError(w *response.Writer, statusCode response.StatusCode, allow string) {
+	body := []byte(response.StatusText(statusCode) + "\n")
+	w.WriteStatusLine(statusCode)
+	h := response.GetDefaultHeaders(len(body))
+	if allow != "" {
+		h.Set("Allow", allow)
+	}
+	w.WriteHeaders(h)
+	w.WriteBody(body)
+}
@@ -0,0 +1,127 @@
+package router
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+
+	"httpfromtcp/internal/request"
+	"httpfromtcp/internal/response"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// serve runs a request through rt and parses what it wrote
+func serve(t *testing.T, rt *Router, method, target string) (*http.Response, string) {
+	t.Helper()
+	req, err := request.RequestFromReader(strings.NewReader(method + " " + target + " HTTP/1.1\r\nHost: localhost\r\n\r\n"))
+	require.NoError(t, err)
+
+	var buf bytes.Buffer
+	w := response.NewWriter(&buf)
+	w.SetMethod(method)
+	rt.ServeRequest(w, req)
+	resp, err := http.ReadResponse(bufio.NewReader(&buf), &http.Request{Method: method})
+	require.NoError(t, err)
+	body, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	return resp, string(body)
+}
+
+// echo writes the given text, followed by the named path values
+func echo(text string, names ...string) func(w *response.Writer, req *request.Request) {
+	return func(w *response.Writer, req *request.Request) {
+		body := text
+		for _, name := range names {
+			body += " " + name + "=" + req.PathValue(name)
+		}
+		w.WriteStatusLine(response.StatusCodeSuccess)
+		w.WriteHeaders(response.GetDefaultHeaders(len(body)))
+		w.WriteBody([]byte(body))
+	}
+}
+
+func TestRouter (t *testing.T) {
+	rt := New()
+	rt.Handle("GET", "/", echo("root"))
+	rt.Handle("GET", "/users/{id}", echo("user", "id"))
+	rt.Handle("DELETE", "/users/{id}", echo("delete", "id"))
+	rt.Handle("GET", "/users/me", echo("me"))
+	rt.Handle("GET", "/users/{id}/posts/{post}", echo("post", "id", "post"))
+	rt.Handle("GET", "/static/{path...}", echo("static", "path"))
+
+	// Test: Root
+	resp, body := serve(t, rt, "GET", "/")
+	assert.Equal(t, 200, resp.StatusCode)
+	assert.Equal(t, "root", body)
+
+	// Test: Path parameter
+	_, body = serve(t, rt, "GET", "/users/42")
+	assert.Equal(t, "user id=42", body)
+
+	// Test: Query string is ignored for matching
+	_, body = serve(t, rt, "GET", "/users/42?verbose=1")
+	assert.Equal(t, "user id=42", body)
+
+	// Test: Encoded slash stays inside its segment
+	_, body = serve(t, rt, "GET", "/users/a%2Fb")
+	assert.Equal(t, "user id=a/b", body)
+
+	// Test: Literal wins over parameter
+	_, body = serve(t, rt, "GET", "/users/me")
+	assert.Equal(t, "me", body)
+
+	// Test: Multiple parameters
+	_, body = serve(t, rt, "GET", "/users/42/posts/7")
+	assert.Equal(t, "post id=42 post=7", body)
+
+	// Test: Method selects the handler
+	_, body = serve(t, rt, "DELETE", "/users/42")
+	assert.Equal(t, "delete id=42", body)
+
+	// Test: Wildcard tail
+	_, body = serve(t, rt, "GET", "/static/css/site.css")
+	assert.Equal(t, "static path=css/site.css", body)
+	_, body = serve(t, rt, "GET", "/static/")
+	assert.Equal(t, "static path=", body)
+
+	// Test: Not found
+	resp, _ = serve(t, rt, "GET", "/nope")
+	assert.Equal(t, 404, resp.StatusCode)
+	resp, _ = serve(t, rt, "GET", "/users/42/extra")
+	assert.Equal(t, 404, resp.StatusCode)
+
+	// Test: Method not allowed lists the allowed methods
+	resp, _ = serve(t, rt, "POST", "/users/42")
+	assert.Equal(t, 405, resp.StatusCode)
+	assert.Equal(t, "DELETE, GET, HEAD", resp.Header.Get("Allow"))
+
+	// Test: HEAD falls back to the GET route, without the body
+	resp, body = serve(t, rt, "HEAD", "/users/42")
+	assert.Equal(t, 200, resp.StatusCode)
+	assert.Equal(t, int64(len("user id=42")), resp.ContentLength)
+	assert.Empty(t, body)
+
+	// Test: A HEAD route of its own takes precedence
+	rt.Handle("HEAD", "/users/{id}", echo("head", "id"))
+	var buf bytes.Buffer
+	req, err := request.RequestFromReader(strings.NewReader("HEAD /users/42 HTTP/1.1\r\nHost: localhost\r\n\r\n"))
+	require.NoError(t, err)
+	rt.ServeRequest(response.NewWriter(&buf), req)
+	assert.True(t, strings.HasSuffix(buf.String(), "head id=42"), buf.String())
+}
+
+func TestRouterBadPatterns (t *testing.T) {
+	rt := New()
+	assert.Panics(t, func() { rt.Handle("GET", "users", echo("")) })
+	assert.Panics(t, func() { rt.Handle("GET", "/{path...}/more", echo("")) })
+	assert.Panics(t, func() { rt.Handle("GET", "/{}", echo("")) })
+	assert.Panics(t, func() { rt.Handle("GET", "/a{b}", echo("")) })
+
+	rt.Handle("GET", "/a", echo(""))
+	assert.Panics(t, func() { rt.Handle("GET", "/a", echo("")) })
+}
@@ -0,0 +1,103 @@
+package server
+
+import (
+	"crypto/tls"
+	"net"
+	"sync/atomic"
+	"time"
+)
+
+type connState int32
+
+const (
+	// connStateNew is a connection that has not sent its first request yet,
+	// or at least not all of it may have arrived.
+	connStateNew connState = iota
+	// connStateIdle is a keep-alive connection waiting for its next request.
+	connStateIdle
+	connStateActive
+)
+
+// newConnGracePeriod is how long Shutdown lets a new connection send its
+// first request before closing it.
+const newConnGracePeriod = 5 * time.Second
+
+// conn is an accepted connection tracked by the server so Shutdown can tell
+// which ones are mid-request.
+type conn struct {
+	net.Conn
+	state    atomic.Int32
+	accepted time.Time
+	metrics  *Metrics
+}
+
+func (c *conn) setState(state connState) {
+	old := connState(c.state.Swap(int32(state)))
+	c.metrics.connStateChanged(old, state)
+}
+
+func (c *conn) Read(p []byte) (int, error) {
+	n, err := c.Conn.Read(p)
+	c.metrics.bytesReceived(n)
+	return n, err
+}
+
+func (c *conn) Write(p []byte) (int, error) {
+	n, err := c.Conn.Write(p)
+	c.metrics.bytesSent(n)
+	return n, err
+}
+
+func (c *conn) getState() connState {
+	return connState(c.state.Load())
+}
+
+func (s *Server) trackConn(c *conn, add bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if add {
+		s.conns[c] = struct{}{}
+	} else {
+		delete(s.conns, c)
+	}
+}
+
+// closeIdleConns closes every connection that is not serving a request and
+// reports whether no connections remain at all. A new connection counts as
+// idle only after newConnGracePeriod, since its first request may be in
+// flight.
+func (s *Server) closeIdleConns() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for c := range s.conns {
+		state := c.getState()
+		if state == connStateIdle || (state == connStateNew && time.Since(c.accepted) > newConnGracePeriod) {
+			c.Close()
+			delete(s.conns, c)
+		}
+	}
+	return len(s.conns) == 0
+}
+
+func (s *Server) closeAllConns() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for c := range s.conns {
+		c.Close()
+		delete(s.conns, c)
+	}
+}
+
+// abort closes the connection with a TCP reset instead of an orderly
+// shutdown, so that a client reading a response without a length does not
+// take a truncated body for the whole of it.
+func (c *conn) abort() {
+	netConn := c.Conn
+	if tc, ok := netConn.(*tls.Conn); ok {
+		netConn = tc.NetConn()
+	}
+	if tc, ok := netConn.(*net.TCPConn); ok {
+		tc.SetLinger(0)
+	}
+	c.Close()
+}
@@ -0,0 +1,79 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"httpfromtcp/internal/request"
+	"io"
+	"os"
+	"time"
+)
+
+// Causes of a request's context being cancelled, as reported by
+// context.Cause. A failed write is reported as the write's error, and the
+// context is cancelled with context.Canceled once the handler returns.
+var (
+	ErrClientDisconnected = errors.New("client disconnected")
+	ErrHandlerTimeout     = errors.New("handler timed out")
+	// ErrServerClosed is also returned by ServeListener once the server
+	// has been closed or shut down.
+	ErrServerClosed = errors.New("server closed")
+)
+
+// requestContext derives the context of a request from the server's. Its
+// deadline is the write deadline: a response finished later could not be
+// sent anyway.
+func (s *Server) requestContext(writeDeadline time.Time) (context.Context, context.CancelCauseFunc) {
+	ctx, cancel := context.WithCancelCause(s.ctx)
+	if writeDeadline.IsZero() {
+		return ctx, cancel
+	}
+	ctx, cancelTimeout := context.WithDeadlineCause(ctx, writeDeadline, ErrHandlerTimeout)
+	return ctx, func(cause error) {
+		cancel(cause)
+		cancelTimeout()
+	}
+}
+
+// watchClient cancels a request's context if the client closes the
+// connection while the handler runs. It can only start looking once the
+// body has been read, since until then the handler owns the input. The
+// returned function stops it, and must be called before the parser is
+// used again.
+func watchClient(c *conn, parser *request.Parser, cancel context.CancelCauseFunc) (stop func()) {
+	stopping := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		select {
+		case <-parser.BodyRead():
+		case <-stopping:
+			return
+		}
+		// A pipelined request arriving is fine; only the end of the input
+		// means the client is gone
+		if err := parser.Wait(); err != nil && !errors.Is(err, os.ErrDeadlineExceeded) {
+			cancel(ErrClientDisconnected)
+		}
+	}()
+	return func() {
+		close(stopping)
+		// Unblock the watcher; the caller sets a new deadline afterwards
+		c.SetReadDeadline(time.Unix(1, 0))
+		<-done
+	}
+}
+
+// cancelWriter cancels a request's context when writing the response fails.
+type cancelWriter struct {
+	w      io.Writer
+	cancel context.CancelCauseFunc
+}
+
+func (cw cancelWriter) Write(p []byte) (int, error) {
+	n, err := cw.w.Write(p)
+	if err != nil {
+		cw.cancel(err)
+	}
+	return n, err
+}
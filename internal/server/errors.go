@@ -0,0 +1,70 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"httpfromtcp/internal/request"
+	"httpfromtcp/internal/response"
+	"strings"
+)
+
+// ErrorPage renders the body of the response to a request that could not be
+// parsed, or whose handler panicked. err says what went wrong, for logging
+// or for pages that want more detail; it may describe internals and should
+// not be echoed to untrusted clients as is.
+type ErrorPage func(statusCode response.StatusCode, err error) (contentType string, body []byte)
+
+// ErrAbortHandler can be passed to panic by a handler to abort the response
+// without the panic being logged.
+var ErrAbortHandler = errors.New("abort handler")
+
+// DefaultErrorPage replies with the status code and reason phrase only.
+func DefaultErrorPage(statusCode response.StatusCode, _ error) (string, []byte) {
+	return "text/plain", []byte(fmt.Sprintf("%d %s\n", statusCode, response.StatusText(statusCode)))
+}
+
+// errorStatus picks the response status for a request that failed to parse.
+func errorStatus(err error) response.StatusCode {
+	switch {
+	case errors.Is(err, request.ErrTimeout):
+		return response.StatusCodeRequestTimeout
+	case errors.Is(err, request.ErrMethodNotAllowed):
+		return response.StatusCodeMethodNotAllowed
+	case errors.Is(err, request.ErrUnsupportedVersion):
+		return response.StatusCodeHTTPVersionNotSupported
+	case errors.Is(err, request.ErrUnsupportedTransferEncoding):
+		return response.StatusCodeNotImplemented
+	case errors.Is(err, request.ErrRequestLineTooLong):
+		return response.StatusCodeURITooLong
+	case errors.Is(err, request.ErrHeadersTooLarge):
+		return response.StatusCodeRequestHeaderFieldsTooLarge
+	case errors.Is(err, request.ErrBodyTooLarge):
+		return response.StatusCodeContentTooLarge
+	default:
+		return response.StatusCodeBadRequest
+	}
+}
+
+// writeError answers a request that failed to parse. The connection is
+// always closed afterwards, since what follows on it cannot be trusted.
+func (s *Server) writeError(w *response.Writer, err error) {
+	s.writeStatus(w, errorStatus(err), err)
+}
+
+// writeStatus sends an error response rendered by the configured ErrorPage.
+func (s *Server) writeStatus(w *response.Writer, statusCode response.StatusCode, err error) {
+	page := s.cfg.ErrorPage
+	if page == nil {
+		page = DefaultErrorPage
+	}
+	contentType, body := page(statusCode, err)
+
+	h := response.GetDefaultHeaders(len(body))
+	h.Set("Content-Type", contentType)
+	if statusCode == response.StatusCodeMethodNotAllowed {
+		h.Set("Allow", strings.Join(s.cfg.AllowedMethods, ", "))
+	}
+	w.WriteStatusLine(statusCode)
+	w.WriteHeaders(h)
+	w.WriteBody(body)
+}
@@ -0,0 +1,69 @@
+package server
+
+import (
+	"httpfromtcp/internal/headers"
+	"httpfromtcp/internal/request"
+	"httpfromtcp/internal/response"
+	"io"
+	"strings"
+)
+
+// expectContinueReader sends "100 Continue" the first time the handler
+// reads the body of an "Expect: 100-continue" request. A handler that
+// answers without reading, typically with 417 or 413, never triggers it,
+// and the client then knows not to send the body.
+type expectContinueReader struct {
+	body      io.ReadCloser
+	w         *response.Writer
+	keepAlive bool
+	sent      bool
+}
+
+func (er *expectContinueReader) Read(p []byte) (int, error) {
+	if !er.sent {
+		er.sent = true
+		// Too late if the handler already started its response
+		if err := er.w.WriteInformational(response.StatusCodeContinue, headers.NewHeaders()); err == nil {
+			er.w.SetKeepAlive(er.keepAlive)
+		}
+	}
+	return er.body.Read(p)
+}
+
+func (er *expectContinueReader) Close() error {
+	return er.body.Close()
+}
+
+// handleExpect applies the Expect header. It reports false if the server
+// answered the request itself with 417 Expectation Failed.
+//
+// Until the client has been told to continue, the connection cannot be
+// reused: whether the body follows is up to the client, so the response is
+// sent with "Connection: close" unless the handler reads the body first.
+func handleExpect(w *response.Writer, req *request.Request, keepAlive bool) bool {
+	expect, ok := req.Headers.Get("Expect")
+	// HTTP/1.0 clients cannot know about 100 Continue (RFC 9110 10.1.1)
+	if !ok || req.RequestLine.HttpVersion != "1.1" {
+		return true
+	}
+	if !strings.EqualFold(strings.TrimSpace(expect), "100-continue") {
+		w.SetKeepAlive(false)
+		body := []byte("Unsupported expectation\n")
+		w.WriteStatusLine(response.StatusCodeExpectationFailed)
+		w.WriteHeaders(response.GetDefaultHeaders(len(body)))
+		w.WriteBody(body)
+		return false
+	}
+	// Nothing to wait for without a body
+	if req.ContentLength == 0 {
+		return true
+	}
+
+	w.SetKeepAlive(false)
+	req.Body = &expectContinueReader{
+		body:      req.Body,
+		w:         w,
+		keepAlive: keepAlive,
+	}
+	return true
+}
@@ -0,0 +1,171 @@
+package server
+
+import (
+	"errors"
+	"httpfromtcp/internal/response"
+	"io"
+	"log"
+	"net"
+	"syscall"
+	"time"
+)
+
+// ConnLimitPolicy says what happens to connections over Config.MaxConns.
+type ConnLimitPolicy int
+
+const (
+	// ConnLimitQueue stops accepting until a connection closes, leaving new
+	// ones waiting in the listen backlog.
+	ConnLimitQueue ConnLimitPolicy = iota
+	// ConnLimitReject accepts them, answers 503 Service Unavailable and
+	// closes them.
+	ConnLimitReject
+)
+
+var (
+	errTooManyConns      = errors.New("too many connections")
+	errTooManyConnsForIP = errors.New("too many connections from client")
+)
+
+// rejectTimeout bounds how long answering a rejected connection may take.
+const rejectTimeout = time.Second
+
+// maxConcurrentRejects caps how many rejected connections are answered at
+// once. Past it, under a flood, they are closed without a response.
+const maxConcurrentRejects = 64
+
+// acquireSlot waits for room under MaxConns, or reports whether there is
+// room for the ConnLimitReject policy. It returns false when the server is
+// closing.
+func (s *Server) acquireSlot(wait bool) bool {
+	if s.slots == nil {
+		return true
+	}
+	if !wait {
+		select {
+		case s.slots <- struct{}{}:
+			return true
+		default:
+			return false
+		}
+	}
+	select {
+	case s.slots <- struct{}{}:
+		return true
+	case <-s.quit:
+		return false
+	}
+}
+
+func (s *Server) releaseSlot() {
+	if s.slots != nil {
+		<-s.slots
+	}
+}
+
+// clientIP returns the key connections are counted under for
+// MaxConnsPerIP, or "" for addresses without an IP such as Unix sockets.
+func clientIP(addr net.Addr) string {
+	tcpAddr, ok := addr.(*net.TCPAddr)
+	if !ok {
+		return ""
+	}
+	return tcpAddr.IP.String()
+}
+
+// addClientConn counts a connection from ip, reporting false if that takes
+// the client over MaxConnsPerIP.
+func (s *Server) addClientConn(ip string) bool {
+	if s.cfg.MaxConnsPerIP <= 0 || ip == "" {
+		return true
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.connsPerIP[ip] >= s.cfg.MaxConnsPerIP {
+		return false
+	}
+	s.connsPerIP[ip]++
+	return true
+}
+
+func (s *Server) removeClientConn(ip string) {
+	if s.cfg.MaxConnsPerIP <= 0 || ip == "" {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.connsPerIP[ip]--
+	if s.connsPerIP[ip] <= 0 {
+		delete(s.connsPerIP, ip)
+	}
+}
+
+// tryReject answers c with reject in the background if fewer than
+// maxConcurrentRejects are in progress, and otherwise just closes it.
+func (s *Server) tryReject(c net.Conn, statusCode response.StatusCode, err error) {
+	select {
+	case s.rejects <- struct{}{}:
+		go func() {
+			defer func() { <-s.rejects }()
+			s.reject(c, statusCode, err)
+		}()
+	default:
+		c.Close()
+	}
+}
+
+// reject answers a connection over a limit without reading its request,
+// then closes it.
+func (s *Server) reject(c net.Conn, statusCode response.StatusCode, err error) {
+	defer c.Close()
+	c.SetDeadline(time.Now().Add(rejectTimeout))
+	w := response.NewWriter(c)
+	s.writeStatus(w, statusCode, err)
+
+	// Closing with the request unread would reset the connection and could
+	// destroy the response before the client reads it, so stop sending and
+	// give the client a moment to finish
+	netConn := c
+	if tc, ok := netConn.(interface{ NetConn() net.Conn }); ok {
+		netConn = tc.NetConn()
+	}
+	if tc, ok := netConn.(*net.TCPConn); ok {
+		tc.CloseWrite()
+	}
+	io.Copy(io.Discard, io.LimitReader(c, 64<<10))
+}
+
+const (
+	minAcceptBackoff = 5 * time.Millisecond
+	maxAcceptBackoff = time.Second
+)
+
+// temporaryAcceptError reports whether Accept may succeed if tried again
+// later, as when the process is out of file descriptors.
+func temporaryAcceptError(err error) bool {
+	for _, errno := range []syscall.Errno{syscall.EMFILE, syscall.ENFILE, syscall.ENOBUFS, syscall.ENOMEM, syscall.ECONNABORTED} {
+		if errors.Is(err, errno) {
+			return true
+		}
+	}
+	var ne net.Error
+	return errors.As(err, &ne) && ne.Timeout()
+}
+
+// acceptBackoff sleeps after a temporary Accept error, twice as long as the
+// previous time up to maxAcceptBackoff. It returns the delay to pass next
+// time, or false if the server closed meanwhile.
+func (s *Server) acceptBackoff(err error, delay time.Duration) (time.Duration, bool) {
+	if delay == 0 {
+		delay = minAcceptBackoff
+	} else {
+		delay = min(2*delay, maxAcceptBackoff)
+	}
+	log.Printf("Error accepting connection: %v; retrying in %v", err, delay)
+	select {
+	case <-time.After(delay):
+		return delay, true
+	case <-s.quit:
+		return delay, false
+	}
+}
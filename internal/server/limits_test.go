@@ -0,0 +1,220 @@
+package server
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+
+	"httpfromtcp/internal/response"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// openConn dials addr and completes one keep-alive request, so that the
+// server is known to hold the connection
+func openConn(t *testing.T, addr string) net.Conn {
+	t.Helper()
+	conn, err := net.Dial("tcp", addr)
+	require.NoError(t, err)
+	t.Cleanup(func() { conn.Close() })
+	_, err = conn.Write([]byte("GET / HTTP/1.1\r\nHost: localhost\r\n\r\n"))
+	require.NoError(t, err)
+	resp, err := http.ReadResponse(bufio.NewReader(conn), nil)
+	require.NoError(t, err)
+	io.ReadAll(resp.Body)
+	return conn
+}
+
+func TestServerMaxConnsReject (t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.MaxConns = 1
+	cfg.MaxConnsPolicy = ConnLimitReject
+	addr := startServer(t, cfg, okHandler)
+
+	// Test: A connection over the limit gets 503 and is closed
+	held := openConn(t, addr)
+	resp := roundTripRaw(t, addr, "GET / HTTP/1.1\r\nHost: localhost\r\n\r\n")
+	assert.Equal(t, response.StatusCodeServiceUnavailable, response.StatusCode(resp.StatusCode))
+	assert.True(t, resp.Close)
+	body, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	assert.Equal(t, fmt.Sprintf("%d %s\n", resp.StatusCode, response.StatusText(response.StatusCodeServiceUnavailable)), string(body))
+
+	// Test: The slot is free again once the held connection closes
+	held.Close()
+	assert.Eventually(t, func() bool {
+		resp := roundTripRaw(t, addr, "GET / HTTP/1.1\r\nHost: localhost\r\n\r\n")
+		return resp.StatusCode == http.StatusOK
+	}, time.Second, 10*time.Millisecond)
+}
+
+func TestServerMaxConnsQueue (t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.MaxConns = 1
+	addr := startServer(t, cfg, okHandler)
+	held := openConn(t, addr)
+
+	// Test: A connection over the limit waits instead of being served
+	conn, err := net.Dial("tcp", addr)
+	require.NoError(t, err)
+	defer conn.Close()
+	_, err = conn.Write([]byte("GET / HTTP/1.1\r\nHost: localhost\r\n\r\n"))
+	require.NoError(t, err)
+	reader := bufio.NewReader(conn)
+	conn.SetReadDeadline(time.Now().Add(100 * time.Millisecond))
+	_, err = reader.Peek(1)
+	require.ErrorIs(t, err, os.ErrDeadlineExceeded)
+
+	// Test: It is served once the held connection closes
+	held.Close()
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	resp, err := http.ReadResponse(reader, nil)
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+}
+
+func TestServerMaxConnsPerIP (t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.MaxConnsPerIP = 2
+	addr := startServer(t, cfg, okHandler)
+
+	// Test: The third connection from the same IP gets 429
+	openConn(t, addr)
+	held := openConn(t, addr)
+	resp := roundTripRaw(t, addr, "GET / HTTP/1.1\r\nHost: localhost\r\n\r\n")
+	assert.Equal(t, response.StatusCodeTooManyRequests, response.StatusCode(resp.StatusCode))
+	assert.True(t, resp.Close)
+
+	// Test: Closing one makes room for another
+	held.Close()
+	assert.Eventually(t, func() bool {
+		resp := roundTripRaw(t, addr, "GET / HTTP/1.1\r\nHost: localhost\r\n\r\n")
+		return resp.StatusCode == http.StatusOK
+	}, time.Second, 10*time.Millisecond)
+}
+
+// flakyListener fails Accept with an error a number of times before handing
+// out conn
+type flakyListener struct {
+	mu       sync.Mutex
+	failures int
+	err      error
+	conn     net.Conn
+	accepts  []time.Time
+	closed   chan struct{}
+}
+
+func (l *flakyListener) Accept() (net.Conn, error) {
+	l.mu.Lock()
+	l.accepts = append(l.accepts, time.Now())
+	if l.failures > 0 {
+		l.failures--
+		l.mu.Unlock()
+		return nil, l.err
+	}
+	conn := l.conn
+	l.conn = nil
+	l.mu.Unlock()
+	if conn != nil {
+		return conn, nil
+	}
+	<-l.closed
+	return nil, net.ErrClosed
+}
+
+func (l *flakyListener) Close() error {
+	close(l.closed)
+	return nil
+}
+
+func (l *flakyListener) Addr() net.Addr {
+	return &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1)}
+}
+
+func TestServerAcceptBackoff (t *testing.T) {
+	client, server := net.Pipe()
+	defer client.Close()
+	l := &flakyListener{
+		failures: 4,
+		err:      &net.OpError{Op: "accept", Net: "tcp", Err: os.NewSyscallError("accept", syscall.EMFILE)},
+		conn:     server,
+		closed:   make(chan struct{}),
+	}
+	s := New(okHandler, DefaultConfig())
+	defer s.Close()
+	require.NoError(t, s.ServeListener(l))
+
+	// Test: Out of file descriptors, accepting is retried with growing
+	// delays until it succeeds
+	assert.Equal(t, "ok", getOverConn(t, client))
+	l.mu.Lock()
+	accepts := l.accepts
+	l.mu.Unlock()
+	require.GreaterOrEqual(t, len(accepts), 5)
+	for i := 1; i < 5; i++ {
+		gap := accepts[i].Sub(accepts[i-1])
+		assert.GreaterOrEqual(t, gap, minAcceptBackoff<<(i-1), "retry %d", i)
+	}
+
+	// Test: Only errors that may clear up are retried
+	assert.True(t, temporaryAcceptError(l.err))
+	assert.False(t, temporaryAcceptError(net.ErrClosed))
+}
+
+func TestServerAcceptFatal (t *testing.T) {
+	log.SetOutput(io.Discard)
+	t.Cleanup(func() { log.SetOutput(os.Stderr) })
+	l := &flakyListener{
+		failures: 1,
+		err:      errors.New("listener broken"),
+		closed:   make(chan struct{}),
+	}
+	s := New(okHandler, DefaultConfig())
+	defer s.Close()
+	require.NoError(t, s.ServeListener(l))
+
+	// Test: An error that will not clear up closes the listener instead of
+	// leaving it open with nobody accepting
+	select {
+	case <-l.closed:
+	case <-time.After(time.Second):
+		t.Fatal("listener not closed")
+	}
+	assert.Eventually(t, func() bool { return len(s.Addrs()) == 0 }, time.Second, 10*time.Millisecond)
+}
+
+func TestServerRejectLimit (t *testing.T) {
+	s := New(okHandler, DefaultConfig())
+	defer s.Close()
+
+	// Test: Past maxConcurrentRejects, connections are closed unanswered
+	for i := 0; i < maxConcurrentRejects; i++ {
+		s.rejects <- struct{}{}
+	}
+	client, server := net.Pipe()
+	defer client.Close()
+	s.tryReject(server, response.StatusCodeServiceUnavailable, errTooManyConns)
+	client.SetReadDeadline(time.Now().Add(time.Second))
+	_, err := client.Read(make([]byte, 1))
+	require.ErrorIs(t, err, io.EOF)
+
+	// Test: Once one finishes, the next is answered again
+	<-s.rejects
+	client, server = net.Pipe()
+	defer client.Close()
+	s.tryReject(server, response.StatusCodeServiceUnavailable, errTooManyConns)
+	client.SetReadDeadline(time.Now().Add(time.Second))
+	resp, err := http.ReadResponse(bufio.NewReader(client), nil)
+	require.NoError(t, err)
+	assert.Equal(t, response.StatusCodeServiceUnavailable, response.StatusCode(resp.StatusCode))
+}
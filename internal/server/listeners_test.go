@@ -0,0 +1,107 @@
+package server
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// getOverConn sends a GET on conn and returns the response body
+func getOverConn(t *testing.T, conn net.Conn) string {
+	t.Helper()
+	defer conn.Close()
+	_, err := conn.Write([]byte("GET / HTTP/1.1\r\nHost: localhost\r\nConnection: close\r\n\r\n"))
+	require.NoError(t, err)
+	resp, err := http.ReadResponse(bufio.NewReader(conn), nil)
+	require.NoError(t, err)
+	body, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	return string(body)
+}
+
+func TestServerMultipleListeners (t *testing.T) {
+	socket := filepath.Join(t.TempDir(), "server.sock")
+	s := New(okHandler, DefaultConfig())
+	defer s.Close()
+
+	// Test: TCP on a specific address, a Unix socket and a pre-opened listener
+	require.NoError(t, s.Listen("tcp4", "127.0.0.1:0"))
+	require.NoError(t, s.Listen("unix", socket))
+	preopened, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	require.NoError(t, s.ServeListener(preopened))
+
+	addrs := s.Addrs()
+	require.Len(t, addrs, 3)
+	assert.Equal(t, addrs[0], s.Addr())
+	for _, addr := range addrs {
+		conn, err := net.Dial(addr.Network(), addr.String())
+		require.NoError(t, err)
+		assert.Equal(t, "ok", getOverConn(t, conn), addr.String())
+	}
+
+	// Test: Close stops every listener and removes the socket file
+	require.NoError(t, s.Close())
+	for _, addr := range addrs {
+		_, err := net.Dial(addr.Network(), addr.String())
+		require.Error(t, err, addr.String())
+	}
+	_, err = os.Stat(socket)
+	require.ErrorIs(t, err, os.ErrNotExist)
+
+	// Test: No more listeners once closed
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer l.Close()
+	require.ErrorIs(t, s.ServeListener(l), ErrServerClosed)
+}
+
+func TestServerUnixSocketStale (t *testing.T) {
+	socket := filepath.Join(t.TempDir(), "server.sock")
+
+	// Test: Socket file left by a dead process is replaced
+	l, err := net.Listen("unix", socket)
+	require.NoError(t, err)
+	l.(*net.UnixListener).SetUnlinkOnClose(false)
+	l.Close()
+	s, err := ServeAddr("unix", socket, okHandler, DefaultConfig())
+	require.NoError(t, err)
+	defer s.Close()
+	conn, err := net.Dial("unix", socket)
+	require.NoError(t, err)
+	assert.Equal(t, "ok", getOverConn(t, conn))
+
+	// Test: Socket in use is left alone
+	_, err = ServeAddr("unix", socket, okHandler, DefaultConfig())
+	require.Error(t, err)
+
+	// Test: Regular file at the path is not removed
+	file := filepath.Join(t.TempDir(), "not-a-socket")
+	require.NoError(t, os.WriteFile(file, nil, 0o600))
+	_, err = ServeAddr("unix", file, okHandler, DefaultConfig())
+	require.Error(t, err)
+	_, err = os.Stat(file)
+	require.NoError(t, err)
+}
+
+func TestSystemdListeners (t *testing.T) {
+	// Test: Not socket activated
+	t.Setenv("LISTEN_PID", "")
+	listeners, err := SystemdListeners()
+	require.NoError(t, err)
+	assert.Nil(t, listeners)
+
+	// Test: Activation meant for another process
+	t.Setenv("LISTEN_PID", "1")
+	t.Setenv("LISTEN_FDS", "1")
+	listeners, err = SystemdListeners()
+	require.NoError(t, err)
+	assert.Nil(t, listeners)
+}
@@ -0,0 +1,151 @@
+package server
+
+import (
+	"errors"
+	"httpfromtcp/internal/metrics"
+	"httpfromtcp/internal/request"
+	"httpfromtcp/internal/response"
+	"strconv"
+	"time"
+)
+
+// Metrics are the instruments a server updates when Config.Metrics is set.
+// Several servers may share one.
+type Metrics struct {
+	requests    *metrics.Counter
+	duration    *metrics.Histogram
+	received    *metrics.Counter
+	sent        *metrics.Counter
+	conns       *metrics.Gauge
+	parseErrors *metrics.Counter
+}
+
+// NewMetrics registers the server's metrics with reg. Serve them with
+// metrics.Handler.
+func NewMetrics(reg *metrics.Registry) *Metrics {
+	return &Metrics{
+		requests: reg.NewCounter("http_requests_total",
+			"Requests handled, by method and response status.", "method", "code"),
+		duration: reg.NewHistogram("http_request_duration_seconds",
+			"Time from the end of the request headers to the handler returning.", metrics.DefaultBuckets, "method"),
+		received: reg.NewCounter("http_received_bytes_total",
+			"Bytes read from client connections."),
+		sent: reg.NewCounter("http_sent_bytes_total",
+			"Bytes written to client connections."),
+		conns: reg.NewGauge("http_connections",
+			"Open client connections, by whether they are serving a request.", "state"),
+		parseErrors: reg.NewCounter("http_parse_errors_total",
+			"Requests rejected because they could not be parsed, by reason.", "reason"),
+	}
+}
+
+// Methods are reported as is only if they are standard, so that clients
+// cannot create any number of series.
+var metricMethods = map[string]bool{
+	"GET": true, "HEAD": true, "POST": true, "PUT": true, "DELETE": true,
+	"CONNECT": true, "OPTIONS": true, "TRACE": true, "PATCH": true,
+}
+
+func metricMethod(method string) string {
+	if metricMethods[method] {
+		return method
+	}
+	return "OTHER"
+}
+
+// The methods below do nothing on a nil *Metrics, so the server can call
+// them whether metrics are enabled or not.
+
+func (m *Metrics) observeRequest(method string, statusCode response.StatusCode, elapsed time.Duration) {
+	if m == nil {
+		return
+	}
+	method = metricMethod(method)
+	m.requests.Inc(method, strconv.Itoa(int(statusCode)))
+	m.duration.Observe(elapsed.Seconds(), method)
+}
+
+func (m *Metrics) observeParseError(err error) {
+	if m == nil {
+		return
+	}
+	m.parseErrors.Inc(parseErrorReason(err))
+}
+
+// parseErrorReason names the sentinel error from package request behind
+// err.
+func parseErrorReason(err error) string {
+	reasons := []struct {
+		err    error
+		reason string
+	}{
+		{request.ErrTimeout, "timeout"},
+		{request.ErrMethodNotAllowed, "method_not_allowed"},
+		{request.ErrUnsupportedVersion, "unsupported_version"},
+		{request.ErrUnsupportedTransferEncoding, "unsupported_transfer_encoding"},
+		{request.ErrTransferEncodingHTTP10, "transfer_encoding_http10"},
+		{request.ErrContentLengthWithTransferEncoding, "content_length_with_transfer_encoding"},
+		{request.ErrConflictingContentLength, "conflicting_content_length"},
+		{request.ErrInvalidContentLength, "invalid_content_length"},
+		{request.ErrInvalidChunkSize, "invalid_chunk_size"},
+		{request.ErrBareLF, "bare_lf"},
+		{request.ErrRequestLineTooLong, "request_line_too_long"},
+		{request.ErrHeadersTooLarge, "headers_too_large"},
+		{request.ErrBodyTooLarge, "body_too_large"},
+		{request.ErrMalformedRequestLine, "malformed_request_line"},
+		{request.ErrInvalidMethod, "invalid_method"},
+		{request.ErrInvalidTarget, "invalid_target"},
+		{request.ErrInvalidHeader, "invalid_header"},
+		{request.ErrInvalidHost, "invalid_host"},
+		{request.ErrIncompleteRequest, "incomplete_request"},
+	}
+	for _, r := range reasons {
+		if errors.Is(err, r.err) {
+			return r.reason
+		}
+	}
+	return "other"
+}
+
+// connOpened counts a new connection, reported as idle until its first
+// request arrives.
+func (m *Metrics) connOpened() {
+	if m == nil {
+		return
+	}
+	m.conns.Inc(connStateLabel(connStateNew))
+}
+
+func (m *Metrics) connStateChanged(from, to connState) {
+	if m == nil || connStateLabel(from) == connStateLabel(to) {
+		return
+	}
+	m.conns.Dec(connStateLabel(from))
+	m.conns.Inc(connStateLabel(to))
+}
+
+func (m *Metrics) connClosed(state connState) {
+	if m == nil {
+		return
+	}
+	m.conns.Dec(connStateLabel(state))
+}
+
+func connStateLabel(state connState) string {
+	if state == connStateActive {
+		return "active"
+	}
+	return "idle"
+}
+
+func (m *Metrics) bytesReceived(n int) {
+	if m != nil && n > 0 {
+		m.received.Add(float64(n))
+	}
+}
+
+func (m *Metrics) bytesSent(n int) {
+	if m != nil && n > 0 {
+		m.sent.Add(float64(n))
+	}
+}
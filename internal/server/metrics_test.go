@@ -0,0 +1,81 @@
+package server
+
+import (
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"httpfromtcp/internal/metrics"
+	"httpfromtcp/internal/request"
+	"httpfromtcp/internal/response"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestServerMetrics (t *testing.T) {
+	reg := metrics.NewRegistry()
+	cfg := DefaultConfig()
+	cfg.Metrics = NewMetrics(reg)
+	m := cfg.Metrics
+	serveMetrics := metrics.Handler(reg)
+	addr := startServer(t, cfg, func(w *response.Writer, req *request.Request) {
+		if req.RequestLine.RequestTarget == "/metrics" {
+			serveMetrics(w, req)
+			return
+		}
+		okHandler(w, req)
+	})
+
+	// Test: Requests are counted by method and status, with their latency
+	roundTripRaw(t, addr, "GET / HTTP/1.1\r\nHost: localhost\r\nConnection: close\r\n\r\n")
+	roundTripRaw(t, addr, "BREW / HTTP/1.1\r\nHost: localhost\r\nConnection: close\r\n\r\n")
+	assert.Equal(t, float64(1), m.requests.Value("GET", "200"))
+	assert.Equal(t, float64(1), m.requests.Value("OTHER", "200"))
+	assert.Equal(t, uint64(1), m.duration.Count("GET"))
+
+	// Test: Parse errors are counted by reason
+	roundTripRaw(t, addr, "GET / HTTP/1.1\r\n\r\n")
+	roundTripRaw(t, addr, "GET / HTTP/2.0\r\nHost: localhost\r\n\r\n")
+	assert.Equal(t, float64(1), m.parseErrors.Value("invalid_host"))
+	assert.Equal(t, float64(1), m.parseErrors.Value("unsupported_version"))
+
+	// Test: Open connections by state, and bytes both ways
+	held := openConn(t, addr)
+	assert.Eventually(t, func() bool {
+		return m.conns.Value("idle") == 1 && m.conns.Value("active") == 0
+	}, time.Second, 10*time.Millisecond)
+	held.Close()
+	assert.Eventually(t, func() bool { return m.conns.Value("idle") == 0 }, time.Second, 10*time.Millisecond)
+	assert.Greater(t, m.received.Value(), float64(0))
+	assert.Greater(t, m.sent.Value(), float64(0))
+
+	// Test: The handler serves them in the text format
+	resp := roundTripRaw(t, addr, "GET /metrics HTTP/1.1\r\nHost: localhost\r\nConnection: close\r\n\r\n")
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, metrics.ContentType, resp.Header.Get("Content-Type"))
+	body, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	assert.Contains(t, string(body), "http_requests_total{method=\"GET\",code=\"200\"} 2\n")
+	assert.Contains(t, string(body), "http_parse_errors_total{reason=\"invalid_host\"} 1\n")
+	assert.Contains(t, string(body), "http_connections{state=\"active\"} 1\n")
+	assert.Contains(t, string(body), "# TYPE http_request_duration_seconds histogram\n")
+}
+
+func TestParseErrorReason (t *testing.T) {
+	// Test: Wrapped sentinels are recognised
+	_, err := request.NewParser(strings.NewReader("GET / HTTP/1.1\r\nBad Header: x\r\n\r\n")).Next()
+	require.Error(t, err)
+	assert.Equal(t, "invalid_header", parseErrorReason(err))
+	assert.Equal(t, "other", parseErrorReason(io.ErrUnexpectedEOF))
+
+	// Test: Smuggling attempts are told apart
+	_, err = request.NewParser(strings.NewReader("POST / HTTP/1.1\r\nHost: a\r\nContent-Length: 5\r\nTransfer-Encoding: chunked\r\n\r\n")).Next()
+	require.Error(t, err)
+	assert.Equal(t, "content_length_with_transfer_encoding", parseErrorReason(err))
+	_, err = request.NewParser(strings.NewReader("GET / HTTP/1.1\nHost: a\r\n\r\n")).Next()
+	require.Error(t, err)
+	assert.Equal(t, "bare_lf", parseErrorReason(err))
+}
@@ -0,0 +1,268 @@
+package server
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"httpfromtcp/internal/request"
+	"httpfromtcp/internal/response"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// FromHTTPHandler runs a net/http handler on this server. The handler gets
+// an *http.Request built from the parsed request and an
+// http.ResponseWriter, which also implements http.Flusher, on top of the
+// automatic framing of response.Writer.
+func FromHTTPHandler(h http.Handler) Handler {
+	return func(w *response.Writer, req *request.Request) {
+		r, err := newHTTPRequest(req)
+		if err != nil {
+			w.WriteHeader(response.StatusCodeBadRequest)
+			return
+		}
+		// A net/http handler aborts with its own sentinel
+		defer func() {
+			if v := recover(); v != nil {
+				if v == http.ErrAbortHandler {
+					panic(ErrAbortHandler)
+				}
+				panic(v)
+			}
+		}()
+		h.ServeHTTP(&httpResponseWriter{w: w, header: http.Header{}}, r)
+	}
+}
+
+func newHTTPRequest(req *request.Request) (*http.Request, error) {
+	target := req.RequestLine.Target
+	u := &url.URL{Host: target.Authority}
+	if target.Form != request.TargetFormAuthority {
+		var err error
+		u, err = url.ParseRequestURI(req.RequestLine.RequestTarget)
+		if err != nil {
+			return nil, err
+		}
+	}
+	r := (&http.Request{
+		Method:        req.RequestLine.Method,
+		URL:           u,
+		Proto:         "HTTP/" + req.RequestLine.HttpVersion,
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        http.Header{},
+		Body:          req.Body,
+		ContentLength: req.ContentLength,
+		RequestURI:    req.RequestLine.RequestTarget,
+		TLS:           req.TLS,
+		RemoteAddr:    req.RemoteAddr,
+	}).WithContext(req.Context())
+	if req.RequestLine.HttpVersion == "1.0" {
+		r.ProtoMinor = 0
+	}
+	for _, f := range req.Headers.Fields() {
+		r.Header.Add(f.Name, f.Value)
+	}
+	// net/http keeps these out of Header
+	r.Host = target.Authority
+	if r.Host == "" {
+		r.Host = r.Header.Get("Host")
+	}
+	r.Header.Del("Host")
+	if req.ContentLength == -1 {
+		r.TransferEncoding = []string{"chunked"}
+		r.Header.Del("Transfer-Encoding")
+	}
+	return r, nil
+}
+
+// httpResponseWriter is an http.ResponseWriter over response.Writer. The
+// http.Header is copied across whenever a status line is about to be sent.
+type httpResponseWriter struct {
+	w           *response.Writer
+	header      http.Header
+	wroteHeader bool
+}
+
+func (rw *httpResponseWriter) Header() http.Header {
+	return rw.header
+}
+
+func (rw *httpResponseWriter) WriteHeader(statusCode int) {
+	if rw.wroteHeader {
+		return
+	}
+	rw.syncHeader()
+	if statusCode >= 200 {
+		rw.wroteHeader = true
+	}
+	rw.w.WriteHeader(response.StatusCode(statusCode))
+}
+
+func (rw *httpResponseWriter) Write(p []byte) (int, error) {
+	rw.WriteHeader(http.StatusOK)
+	return rw.w.Write(p)
+}
+
+func (rw *httpResponseWriter) Flush() {
+	rw.WriteHeader(http.StatusOK)
+	rw.w.Flush()
+}
+
+func (rw *httpResponseWriter) syncHeader() {
+	h := rw.w.Header()
+	for key, values := range rw.header {
+		h.Del(key)
+		for _, value := range values {
+			h.Add(key, value)
+		}
+	}
+}
+
+// ToHTTPHandler runs a handler written for this server under net/http, for
+// instance with httptest to compare behaviour with the standard library.
+// The request head is re-parsed with the request package; the response is
+// written to a pipe and read back with http.ReadResponse. A panic in the
+// handler is raised again in ServeHTTP, for net/http to recover.
+func ToHTTPHandler(h Handler) http.Handler {
+	return http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		req, err := newRequest(r)
+		if err != nil {
+			http.Error(rw, response.StatusText(response.StatusCodeBadRequest), http.StatusBadRequest)
+			return
+		}
+
+		pr, pw := io.Pipe()
+		panicked := make(chan any, 1)
+		defer func() {
+			pr.Close()
+			if v := <-panicked; v != nil {
+				if v == ErrAbortHandler {
+					v = http.ErrAbortHandler
+				}
+				panic(v)
+			}
+		}()
+		go func() {
+			defer func() {
+				v := recover()
+				if v != nil {
+					pw.CloseWithError(errHandlerPanicked)
+				}
+				panicked <- v
+			}()
+			w := response.NewWriter(pw)
+			w.SetVersion(req.RequestLine.HttpVersion)
+			w.SetMethod(req.RequestLine.Method)
+			w.SetKeepAlive(true)
+			h(w, req)
+			pw.CloseWithError(w.Finish())
+		}()
+
+		reader := bufio.NewReader(pr)
+		for {
+			resp, err := http.ReadResponse(reader, r)
+			if errors.Is(err, errHandlerPanicked) {
+				return
+			}
+			if err != nil {
+				http.Error(rw, response.StatusText(response.StatusCodeInternalServerError), http.StatusInternalServerError)
+				return
+			}
+			copyResponse(rw, resp)
+			if resp.StatusCode >= 200 {
+				return
+			}
+		}
+	})
+}
+
+var errHandlerPanicked = errors.New("handler panicked")
+
+// newRequest builds a request.Request by parsing the head of r, so that it
+// gets the same validation as one read from a connection.
+func newRequest(r *http.Request) (*request.Request, error) {
+	var head strings.Builder
+	target := r.RequestURI
+	if target == "" {
+		target = r.URL.RequestURI()
+	}
+	version := "1.1"
+	if r.ProtoMajor == 1 && r.ProtoMinor == 0 {
+		version = "1.0"
+	}
+	fmt.Fprintf(&head, "%s %s HTTP/%s\r\n", r.Method, target, version)
+	if r.Host != "" {
+		fmt.Fprintf(&head, "Host: %s\r\n", r.Host)
+	}
+	for key, values := range r.Header {
+		// The body is passed on already decoded
+		if key == "Content-Length" || key == "Transfer-Encoding" {
+			continue
+		}
+		for _, value := range values {
+			fmt.Fprintf(&head, "%s: %s\r\n", key, value)
+		}
+	}
+	head.WriteString("\r\n")
+
+	req, err := request.RequestFromReader(strings.NewReader(head.String()))
+	if err != nil {
+		return nil, err
+	}
+	req.ContentLength = r.ContentLength
+	if r.ContentLength > 0 {
+		req.Headers.Set("Content-Length", fmt.Sprintf("%d", r.ContentLength))
+	}
+	if r.ContentLength == -1 {
+		req.Headers.Set("Transfer-Encoding", "chunked")
+	}
+	if r.Body != nil {
+		req.Body = r.Body
+	}
+	req.TLS = r.TLS
+	req.RemoteAddr = r.RemoteAddr
+	return req.WithContext(r.Context()), nil
+}
+
+// copyResponse writes resp to rw, leaving out the fields that only applied
+// to the pipe's framing.
+func copyResponse(rw http.ResponseWriter, resp *http.Response) {
+	defer resp.Body.Close()
+	h := rw.Header()
+	for key, values := range resp.Header {
+		if key == "Connection" || key == "Transfer-Encoding" {
+			continue
+		}
+		h[key] = values
+	}
+	rw.WriteHeader(resp.StatusCode)
+	if resp.StatusCode < 200 {
+		for key := range resp.Header {
+			h.Del(key)
+		}
+		return
+	}
+
+	flusher, _ := rw.(http.Flusher)
+	buf := make([]byte, 32<<10)
+	for {
+		n, err := resp.Body.Read(buf)
+		if n > 0 {
+			if _, werr := rw.Write(buf[:n]); werr != nil {
+				return
+			}
+			if flusher != nil {
+				flusher.Flush()
+			}
+		}
+		if err != nil {
+			break
+		}
+	}
+	for key, values := range resp.Trailer {
+		h[http.TrailerPrefix+key] = values
+	}
+}
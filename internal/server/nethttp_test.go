@@ -0,0 +1,170 @@
+package server
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+
+	"httpfromtcp/internal/headers"
+	"httpfromtcp/internal/request"
+	"httpfromtcp/internal/response"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFromHTTPHandler (t *testing.T) {
+	addr := startServer(t, DefaultConfig(), FromHTTPHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		w.Header().Set("Content-Type", "text/plain")
+		w.Header().Add("X-Echo", r.Header.Get("X-Test"))
+		w.Header().Set("X-Remote-Addr", r.RemoteAddr)
+		if r.URL.Query().Get("stream") != "" {
+			w.(http.Flusher).Flush()
+		}
+		w.WriteHeader(http.StatusCreated)
+		fmt.Fprintf(w, "%s %s %s %s", r.Method, r.URL.Path, r.Host, body)
+	})))
+
+	// Test: Request and response round trip through net/http types
+	req, err := http.NewRequest("POST", "http://"+addr+"/things?a=1", strings.NewReader("hello"))
+	require.NoError(t, err)
+	req.Header.Set("X-Test", "value")
+	resp, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	body, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusCreated, resp.StatusCode)
+	assert.Equal(t, "value", resp.Header.Get("X-Echo"))
+	_, _, err = net.SplitHostPort(resp.Header.Get("X-Remote-Addr"))
+	assert.NoError(t, err)
+	assert.Equal(t, "POST /things "+addr+" hello", string(body))
+	assert.Equal(t, int64(len(body)), resp.ContentLength)
+
+	// Test: Flush switches to chunked
+	resp, err = http.Get("http://" + addr + "/stream?stream=1")
+	require.NoError(t, err)
+	body, err = io.ReadAll(resp.Body)
+	resp.Body.Close()
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, []string{"chunked"}, resp.TransferEncoding)
+	assert.Equal(t, "GET /stream "+addr+" ", string(body))
+}
+
+func TestToHTTPHandler (t *testing.T) {
+	ts := httptest.NewServer(ToHTTPHandler(func(w *response.Writer, req *request.Request) {
+		body, _ := io.ReadAll(req.Body)
+		if req.RequestLine.Target.Path == "/chunked" {
+			w.WriteStatusLine(response.StatusCodeSuccess)
+			h := headers.NewHeaders()
+			h.Set("Transfer-Encoding", "chunked")
+			h.Set("Trailer", "X-Checksum")
+			w.WriteHeaders(h)
+			w.WriteChunkedBody([]byte("hello "))
+			w.WriteChunkedBody([]byte("world"))
+			w.WriteChunkedBodyDone()
+			trailers := headers.NewHeaders()
+			trailers.Set("X-Checksum", "abc")
+			w.WriteTrailers(trailers)
+			return
+		}
+		host, _ := req.Headers.Get("Host")
+		w.Header().Set("X-Method", req.RequestLine.Method)
+		w.Header().Set("X-Remote-Addr", req.RemoteAddr)
+		w.WriteHeader(response.StatusCodeAccepted)
+		fmt.Fprintf(w, "%s %s", host, body)
+	}))
+	defer ts.Close()
+
+	// Test: Buffered response
+	resp, err := http.Post(ts.URL+"/echo", "text/plain", strings.NewReader("hello"))
+	require.NoError(t, err)
+	body, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusAccepted, resp.StatusCode)
+	assert.Equal(t, "POST", resp.Header.Get("X-Method"))
+	_, _, err = net.SplitHostPort(resp.Header.Get("X-Remote-Addr"))
+	assert.NoError(t, err)
+	assert.Equal(t, strings.TrimPrefix(ts.URL, "http://")+" hello", string(body))
+
+	// Test: Chunked response keeps its trailers
+	resp, err = http.Get(ts.URL + "/chunked")
+	require.NoError(t, err)
+	body, err = io.ReadAll(resp.Body)
+	resp.Body.Close()
+	require.NoError(t, err)
+	assert.Equal(t, "hello world", string(body))
+	assert.Equal(t, "abc", resp.Trailer.Get("X-Checksum"))
+}
+
+func TestHTTPHandlerPanics (t *testing.T) {
+	var logs lockedBuffer
+	log.SetOutput(&logs)
+	t.Cleanup(func() { log.SetOutput(os.Stderr) })
+
+	// Test: A panic under ToHTTPHandler reaches net/http instead of
+	// crashing the process
+	ts := httptest.NewUnstartedServer(ToHTTPHandler(func(w *response.Writer, req *request.Request) {
+		if req.RequestLine.Target.Path == "/abort" {
+			panic(ErrAbortHandler)
+		}
+		panic("boom")
+	}))
+	var serverLogs lockedBuffer
+	ts.Config.ErrorLog = log.New(&serverLogs, "", 0)
+	ts.Start()
+	defer ts.Close()
+	_, err := http.Get(ts.URL + "/")
+	require.Error(t, err)
+	assert.Contains(t, serverLogs.String(), "boom")
+
+	// Test: ErrAbortHandler becomes http.ErrAbortHandler, which net/http
+	// does not log
+	serverLogs.Reset()
+	_, err = http.Get(ts.URL + "/abort")
+	require.Error(t, err)
+	assert.Empty(t, serverLogs.String())
+
+	// Test: http.ErrAbortHandler under FromHTTPHandler aborts without a
+	// stack trace being logged
+	addr := startServer(t, DefaultConfig(), FromHTTPHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		panic(http.ErrAbortHandler)
+	})))
+	_, err = http.Get("http://" + addr + "/")
+	require.Error(t, err)
+	assert.Empty(t, logs.String())
+}
+
+// lockedBuffer collects log output written from server goroutines.
+type lockedBuffer struct {
+	mu  sync.Mutex
+	buf strings.Builder
+}
+
+func (b *lockedBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *lockedBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
+}
+
+func (b *lockedBuffer) Reset() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.buf.Reset()
+}
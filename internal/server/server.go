@@ -1,69 +1,463 @@
 package server
 
 import (
+	"context"
+	"crypto/tls"
+	"errors"
 	"fmt"
+	"httpfromtcp/internal/headers"
 	"httpfromtcp/internal/request"
 	"httpfromtcp/internal/response"
 	"log"
 	"net"
+	"runtime/debug"
+	"slices"
+	"strings"
+	"sync"
 	"sync/atomic"
+	"time"
 )
 
 type Handler func(w *response.Writer, req *request.Request)
 
 type Server struct {
-	handler Handler
-	listener net.Listener
-	closed atomic.Bool
+	handler   Handler
+	listeners []net.Listener
+	closed    atomic.Bool
+	mu        sync.Mutex
+	conns     map[*conn]struct{}
+	cfg       Config
+	// ctx is the parent of every request's context, cancelled when
+	// connections are closed forcibly
+	ctx    context.Context
+	cancel context.CancelCauseFunc
+	// quit is closed when the server stops accepting
+	quit chan struct{}
+	// slots holds a token per connection when MaxConns is set
+	slots chan struct{}
+	// rejects holds a token per connection being answered by reject
+	rejects    chan struct{}
+	connsPerIP map[string]int
+}
+
+type Config struct {
+	// ReadHeaderTimeout bounds reading the request line and headers; a
+	// client that is too slow gets a 408. Zero means no timeout.
+	ReadHeaderTimeout time.Duration
+	// ReadBodyTimeout bounds reading the request body, measured from the end
+	// of the headers. Handlers see it as an error from Body.Read.
+	ReadBodyTimeout time.Duration
+	// WriteTimeout bounds writing the response, measured from the end of
+	// the headers.
+	WriteTimeout time.Duration
+	// IdleTimeout is how long a keep-alive connection may sit between
+	// requests before it is closed. Zero means no timeout.
+	IdleTimeout time.Duration
+	// MaxRequestsPerConn caps how many requests are served on a single
+	// connection. Zero means no limit.
+	MaxRequestsPerConn int
+
+	// Request size limits, answered with 414, 431 and 413 respectively.
+	// Zero means no limit; see request.Options.
+	MaxRequestLineBytes int
+	MaxHeaderBytes      int
+	MaxHeaderCount      int
+	MaxBodyBytes        int64
+
+	// HeaderMode sets how strictly request headers are parsed: strict for
+	// internet-facing ports, lenient for legacy clients.
+	HeaderMode headers.Mode
+	// AllowedMethods, if set, restricts the methods the server accepts;
+	// others get 405 with an Allow header listing these.
+	AllowedMethods []string
+
+	// ErrorPage renders responses to requests that fail to parse. Nil
+	// means DefaultErrorPage.
+	ErrorPage ErrorPage
+
+	// TLSConfig, if set, makes the server speak HTTPS. See CertStore for
+	// certificates chosen by host name and reloaded on change.
+	TLSConfig *tls.Config
+
+	// MaxConns caps how many connections are open at once, with
+	// MaxConnsPolicy saying what happens to the ones over it. Zero means no
+	// limit.
+	MaxConns       int
+	MaxConnsPolicy ConnLimitPolicy
+	// MaxConnsPerIP caps how many connections a single client IP may have
+	// open; the ones over it get 429 and are closed. Zero means no limit.
+	MaxConnsPerIP int
+
+	// Metrics, if set, is updated with request, connection and parse error
+	// counts; see NewMetrics.
+	Metrics *Metrics
+}
+
+func DefaultConfig() Config {
+	opts := request.DefaultOptions()
+	return Config{
+		ReadHeaderTimeout:   10 * time.Second,
+		ReadBodyTimeout:     60 * time.Second,
+		WriteTimeout:        60 * time.Second,
+		IdleTimeout:         60 * time.Second,
+		MaxRequestsPerConn:  100,
+		MaxRequestLineBytes: opts.MaxRequestLineBytes,
+		MaxHeaderBytes:      opts.MaxHeaderBytes,
+		MaxHeaderCount:      opts.MaxHeaderCount,
+		MaxBodyBytes:        opts.MaxBodyBytes,
+	}
+}
+
+func (cfg Config) parserOptions() request.Options {
+	return request.Options{
+		MaxRequestLineBytes: cfg.MaxRequestLineBytes,
+		MaxHeaderBytes:      cfg.MaxHeaderBytes,
+		MaxHeaderCount:      cfg.MaxHeaderCount,
+		MaxBodyBytes:        cfg.MaxBodyBytes,
+		HeaderMode:          cfg.HeaderMode,
+		AllowedMethods:      cfg.AllowedMethods,
+	}
 }
 
 func Serve(port int, handler Handler) (*Server, error) {
-	listener, err := net.Listen("tcp", fmt.Sprintf(":%d", port))
-	if err != nil {
+	return ServeWithConfig(port, handler, DefaultConfig())
+}
+
+func ServeWithConfig(port int, handler Handler, cfg Config) (*Server, error) {
+	return ServeAddr("tcp", fmt.Sprintf(":%d", port), handler, cfg)
+}
+
+// ServeAddr serves on a single address, such as "127.0.0.1:8080" for
+// network "tcp4" or a socket path for "unix".
+func ServeAddr(network, address string, handler Handler, cfg Config) (*Server, error) {
+	s := New(handler, cfg)
+	if err := s.Listen(network, address); err != nil {
 		return nil, err
 	}
-	
-	s := Server{
-		handler: handler,
-		listener: listener,
+	return s, nil
+}
+
+// New returns a server that is not listening yet; call Listen or
+// ServeListener, as many times as needed, to start serving.
+func New(handler Handler, cfg Config) *Server {
+	ctx, cancel := context.WithCancelCause(context.Background())
+	s := &Server{
+		handler:    handler,
+		cfg:        cfg,
+		conns:      make(map[*conn]struct{}),
+		ctx:        ctx,
+		cancel:     cancel,
+		quit:       make(chan struct{}),
+		rejects:    make(chan struct{}, maxConcurrentRejects),
+		connsPerIP: make(map[string]int),
+	}
+	if cfg.MaxConns > 0 {
+		s.slots = make(chan struct{}, cfg.MaxConns)
 	}
-	go s.listen()
-	return &s, nil
+	return s
 }
 
-func (s *Server) Close() error {
-	s.closed.Store(true)
-	if s.listener != nil {
-		return s.listener.Close()
+// Listen opens a listener on network and address and serves it. A stale
+// socket file left behind at a Unix socket path is removed first.
+func (s *Server) Listen(network, address string) error {
+	if strings.HasPrefix(network, "unix") {
+		removeStaleSocket(address)
+	}
+	l, err := net.Listen(network, address)
+	if err != nil {
+		return err
+	}
+	if err := s.ServeListener(l); err != nil {
+		l.Close()
+		return err
 	}
 	return nil
 }
 
-func (s *Server) listen() {
+// ServeListener accepts connections from l, which may have been opened by
+// the caller or inherited (see SystemdListeners), in the background. The
+// server owns l from then on and closes it on Close or Shutdown.
+func (s *Server) ServeListener(l net.Listener) error {
+	if s.cfg.TLSConfig != nil {
+		l = tls.NewListener(l, s.cfg.TLSConfig)
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed.Load() {
+		return ErrServerClosed
+	}
+	s.listeners = append(s.listeners, l)
+	go s.listen(l)
+	return nil
+}
+
+// Addr returns the address of the first listener, or nil if there is none.
+func (s *Server) Addr() net.Addr {
+	addrs := s.Addrs()
+	if len(addrs) == 0 {
+		return nil
+	}
+	return addrs[0]
+}
+
+func (s *Server) Addrs() []net.Addr {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	addrs := make([]net.Addr, len(s.listeners))
+	for i, l := range s.listeners {
+		addrs[i] = l.Addr()
+	}
+	return addrs
+}
+
+// closeListeners stops accepting on every listener, returning the first
+// error.
+func (s *Server) closeListeners() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !s.closed.Swap(true) {
+		close(s.quit)
+	}
+	var err error
+	for _, l := range s.listeners {
+		if cerr := l.Close(); cerr != nil && err == nil {
+			err = cerr
+		}
+	}
+	return err
+}
+
+// dropListener closes l after it failed for good, so that clients are
+// refused rather than left waiting in a backlog nobody accepts from. The
+// server keeps serving on its other listeners.
+func (s *Server) dropListener(l net.Listener) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed.Load() {
+		return
+	}
+	l.Close()
+	s.listeners = slices.DeleteFunc(s.listeners, func(other net.Listener) bool { return other == l })
+}
+
+// Close stops accepting and immediately closes every connection, including
+// those in the middle of a request. See Shutdown for the graceful version.
+func (s *Server) Close() error {
+	err := s.closeListeners()
+	s.cancel(ErrServerClosed)
+	s.closeAllConns()
+	return err
+}
+
+// shutdownPollInterval is how often Shutdown checks for connections that
+// have gone idle.
+const shutdownPollInterval = 50 * time.Millisecond
+
+// Shutdown stops accepting new connections, closes idle keep-alive
+// connections and waits for active ones to finish their current request.
+// If ctx expires first the contexts of the requests still running are
+// cancelled, the remaining connections are closed forcibly and ctx's error
+// is returned.
+func (s *Server) Shutdown(ctx context.Context) error {
+	err := s.closeListeners()
+
+	ticker := time.NewTicker(shutdownPollInterval)
+	defer ticker.Stop()
+	for {
+		if s.closeIdleConns() {
+			s.cancel(ErrServerClosed)
+			return err
+		}
+		select {
+		case <-ctx.Done():
+			s.cancel(ErrServerClosed)
+			s.closeAllConns()
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+func (s *Server) listen(l net.Listener) {
+	queue := s.cfg.MaxConnsPolicy == ConnLimitQueue
+	var backoff time.Duration
 	for {
-		conn, err := s.listener.Accept()
+		// Under the queue policy, stop accepting while at the limit so that
+		// new connections wait in the listen backlog
+		if queue && !s.acquireSlot(true) {
+			return
+		}
+		netConn, err := l.Accept()
 		if err != nil {
+			if queue {
+				s.releaseSlot()
+			}
 			if s.closed.Load() {
 				return
 			}
-			log.Printf("Error accepting connection: %v", err)
+			if !temporaryAcceptError(err) {
+				log.Printf("Error accepting connection: %v; closing listener on %v", err, l.Addr())
+				s.dropListener(l)
+				return
+			}
+			var ok bool
+			if backoff, ok = s.acceptBackoff(err, backoff); !ok {
+				return
+			}
 			continue
 		}
+		backoff = 0
 
-		go s.handle(conn)
+		if !queue && !s.acquireSlot(false) {
+			s.tryReject(netConn, response.StatusCodeServiceUnavailable, errTooManyConns)
+			continue
+		}
+		ip := clientIP(netConn.RemoteAddr())
+		if !s.addClientConn(ip) {
+			s.releaseSlot()
+			s.tryReject(netConn, response.StatusCodeTooManyRequests, errTooManyConnsForIP)
+			continue
+		}
+
+		c := &conn{Conn: netConn, accepted: time.Now(), metrics: s.cfg.Metrics}
+		s.cfg.Metrics.connOpened()
+		s.trackConn(c, true)
+		go s.handle(c, ip)
 	}
 }
 
-func (s *Server) handle(conn net.Conn) {
-	defer conn.Close()
-	w := response.NewWriter(conn)
-	req, err := request.RequestFromReader(conn)
-	if err != nil {
-		w.WriteStatusLine(response.StatusCodeBadRequest)
-		body := []byte(fmt.Sprintf("Error parsing request: %v", err))
-		w.WriteHeaders(response.GetDefaultHeaders(len(body)))
-		w.WriteBody(body)
-		return
+func (s *Server) handle(conn *conn, ip string) {
+	defer func() {
+		// Whatever went wrong, only this connection is affected
+		if v := recover(); v != nil {
+			log.Printf("panic on connection from %s: %v\n%s", conn.RemoteAddr(), v, debug.Stack())
+		}
+		conn.Close()
+		s.trackConn(conn, false)
+		s.cfg.Metrics.connClosed(conn.getState())
+		s.removeClientConn(ip)
+		s.releaseSlot()
+	}()
+	parser := request.NewParserWithOptions(conn, s.cfg.parserOptions())
+
+	for served := 0; s.cfg.MaxRequestsPerConn == 0 || served < s.cfg.MaxRequestsPerConn; served++ {
+		// Wait for the first byte of the next request; a client that closes
+		// a keep-alive connection between requests, or never sends anything,
+		// is not an error. The first request gets no idle allowance.
+		if served == 0 {
+			conn.SetReadDeadline(deadline(s.cfg.ReadHeaderTimeout))
+		} else {
+			conn.SetReadDeadline(deadline(s.cfg.IdleTimeout))
+			conn.setState(connStateIdle)
+		}
+		if err := parser.Wait(); err != nil {
+			return
+		}
+		conn.setState(connStateActive)
+		if served > 0 {
+			conn.SetReadDeadline(deadline(s.cfg.ReadHeaderTimeout))
+		}
+
+		req, err := parser.Next()
+		if err != nil {
+			s.cfg.Metrics.observeParseError(err)
+			conn.SetWriteDeadline(deadline(s.cfg.WriteTimeout))
+			s.writeError(response.NewWriter(conn), err)
+			return
+		}
+		start := time.Now()
+		writeDeadline := deadline(s.cfg.WriteTimeout)
+		conn.SetReadDeadline(deadline(s.cfg.ReadBodyTimeout))
+		conn.SetWriteDeadline(writeDeadline)
+
+		req.RemoteAddr = conn.RemoteAddr().String()
+		if tc, ok := conn.Conn.(*tls.Conn); ok {
+			state := tc.ConnectionState()
+			req.TLS = &state
+		}
+		ctx, cancel := s.requestContext(writeDeadline)
+		req = req.WithContext(ctx)
+		w := response.NewWriter(cancelWriter{w: conn, cancel: cancel})
+
+		lastRequest := s.cfg.MaxRequestsPerConn > 0 && served+1 >= s.cfg.MaxRequestsPerConn
+		keepAlive := !lastRequest && !s.closed.Load() && wantsKeepAlive(req)
+		w.SetVersion(req.RequestLine.HttpVersion)
+		w.SetMethod(req.RequestLine.Method)
+		w.SetKeepAlive(keepAlive)
+		if !handleExpect(w, req, keepAlive) {
+			s.cfg.Metrics.observeRequest(req.RequestLine.Method, w.Status(), time.Since(start))
+			cancel(context.Canceled)
+			return
+		}
+		stopWatching := watchClient(conn, parser, cancel)
+		s.serveRequest(conn, w, req)
+		// A chunked body can only be found too large while the handler reads
+		// it. Answer 413 instead of whatever the handler made of the error,
+		// unless part of its response was sent already
+		if err := parser.BodyErr(); errors.Is(err, request.ErrBodyTooLarge) && !w.Aborted() && w.Reset() {
+			w.SetKeepAlive(false)
+			s.writeStatus(w, response.StatusCodeContentTooLarge, err)
+		}
+		s.cfg.Metrics.observeRequest(req.RequestLine.Method, w.Status(), time.Since(start))
+		stopWatching()
+		cancel(context.Canceled)
+		conn.SetReadDeadline(deadline(s.cfg.ReadBodyTimeout))
+		if w.Aborted() {
+			conn.abort()
+			return
+		}
+		if err := w.Finish(); err != nil {
+			return
+		}
+		if !w.KeepAlive() || s.closed.Load() {
+			return
+		}
+		if err := parser.DiscardBody(); err != nil {
+			return
+		}
 	}
+}
+
+// serveRequest runs the handler, recovering from a panic with a 500 if
+// nothing has been sent yet and aborting the response otherwise.
+func (s *Server) serveRequest(conn *conn, w *response.Writer, req *request.Request) {
+	defer func() {
+		v := recover()
+		if v == nil {
+			return
+		}
+		if v != ErrAbortHandler {
+			log.Printf("panic serving %s %s HTTP/%s for %s: %v\n%s", req.RequestLine.Method, req.RequestLine.RequestTarget,
+				req.RequestLine.HttpVersion, conn.RemoteAddr(), v, debug.Stack())
+		}
+		if v == ErrAbortHandler || !w.Reset() {
+			w.Abort()
+			return
+		}
+		w.SetKeepAlive(false)
+		s.writeStatus(w, response.StatusCodeInternalServerError, fmt.Errorf("panic: %v", v))
+	}()
 	s.handler(w, req)
 }
+
+// wantsKeepAlive reports whether the client is willing to reuse the
+// connection. HTTP/1.1 connections are persistent unless the client sends
+// "Connection: close"; HTTP/1.0 ones only if it sends "Connection: keep-alive".
+func wantsKeepAlive(req *request.Request) bool {
+	if req.Headers.HasToken("Connection", "close") {
+		return false
+	}
+	if req.Headers.HasToken("Connection", "keep-alive") {
+		return true
+	}
+	return req.RequestLine.HttpVersion == "1.1"
+}
+
+// deadline turns a timeout into a connection deadline, where the zero time
+// means none.
+func deadline(timeout time.Duration) time.Time {
+	if timeout <= 0 {
+		return time.Time{}
+	}
+	return time.Now().Add(timeout)
+}
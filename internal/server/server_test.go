@@ -0,0 +1,697 @@
+package server
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"httpfromtcp/internal/headers"
+	"httpfromtcp/internal/request"
+	"httpfromtcp/internal/response"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func okHandler(w *response.Writer, _ *request.Request) {
+	body := []byte("ok")
+	w.WriteStatusLine(response.StatusCodeSuccess)
+	w.WriteHeaders(response.GetDefaultHeaders(len(body)))
+	w.WriteBody(body)
+}
+
+func startServer(t *testing.T, cfg Config, handler Handler) string {
+	t.Helper()
+	s, err := ServeWithConfig(0, handler, cfg)
+	require.NoError(t, err)
+	t.Cleanup(func() { s.Close() })
+	return s.Addr().String()
+}
+
+// slowClient writes its request a few bytes at a time, the way a slowloris
+// client would
+func slowClient(conn net.Conn, data string, bytesPerWrite int, delay time.Duration) {
+	for i := 0; i < len(data); i += bytesPerWrite {
+		end := min(i+bytesPerWrite, len(data))
+		if _, err := conn.Write([]byte(data[i:end])); err != nil {
+			return
+		}
+		time.Sleep(delay)
+	}
+}
+
+// roundTripRaw sends raw on a new connection and reads one response.
+func roundTripRaw(t *testing.T, addr, raw string) *http.Response {
+	t.Helper()
+	conn, err := net.Dial("tcp", addr)
+	require.NoError(t, err)
+	t.Cleanup(func() { conn.Close() })
+	_, err = conn.Write([]byte(raw))
+	require.NoError(t, err)
+	resp, err := http.ReadResponse(bufio.NewReader(conn), nil)
+	require.NoError(t, err)
+	return resp
+}
+
+func TestServerKeepAlive (t *testing.T) {
+	addr := startServer(t, DefaultConfig(), okHandler)
+	conn, err := net.Dial("tcp", addr)
+	require.NoError(t, err)
+	defer conn.Close()
+	reader := bufio.NewReader(conn)
+
+	// Test: Two requests on one connection
+	for i := 0; i < 2; i++ {
+		_, err = conn.Write([]byte("GET / HTTP/1.1\r\nHost: localhost\r\n\r\n"))
+		require.NoError(t, err)
+		resp, err := http.ReadResponse(reader, nil)
+		require.NoError(t, err)
+		body, err := io.ReadAll(resp.Body)
+		require.NoError(t, err)
+		assert.Equal(t, "ok", string(body))
+		assert.Equal(t, "keep-alive", resp.Header.Get("Connection"))
+	}
+
+	// Test: Connection: close ends the connection
+	_, err = conn.Write([]byte("GET / HTTP/1.1\r\nHost: localhost\r\nConnection: close\r\n\r\n"))
+	require.NoError(t, err)
+	resp, err := http.ReadResponse(reader, nil)
+	require.NoError(t, err)
+	io.ReadAll(resp.Body)
+	assert.True(t, resp.Close)
+	_, err = reader.ReadByte()
+	require.ErrorIs(t, err, io.EOF)
+}
+
+func TestServerConnectionClose (t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.MaxRequestsPerConn = 2
+	addr := startServer(t, cfg, func(w *response.Writer, req *request.Request) {
+		if req.RequestLine.Target.Path == "/close" {
+			w.Header().Set("Connection", "close")
+		}
+		okHandler(w, req)
+	})
+
+	// Test: The last request allowed on a connection gets Connection: close
+	conn, err := net.Dial("tcp", addr)
+	require.NoError(t, err)
+	defer conn.Close()
+	reader := bufio.NewReader(conn)
+	for i, wantClose := range []bool{false, true} {
+		_, err = conn.Write([]byte("GET / HTTP/1.1\r\nHost: localhost\r\n\r\n"))
+		require.NoError(t, err)
+		resp, err := http.ReadResponse(reader, nil)
+		require.NoError(t, err)
+		io.ReadAll(resp.Body)
+		assert.Equal(t, wantClose, resp.Close, "request %d", i)
+	}
+	_, err = reader.ReadByte()
+	require.ErrorIs(t, err, io.EOF)
+
+	// Test: A handler sending Connection: close ends the connection
+	conn, err = net.Dial("tcp", addr)
+	require.NoError(t, err)
+	defer conn.Close()
+	reader = bufio.NewReader(conn)
+	_, err = conn.Write([]byte("GET /close HTTP/1.1\r\nHost: localhost\r\n\r\n"))
+	require.NoError(t, err)
+	resp, err := http.ReadResponse(reader, nil)
+	require.NoError(t, err)
+	io.ReadAll(resp.Body)
+	assert.True(t, resp.Close)
+	_, err = reader.ReadByte()
+	require.ErrorIs(t, err, io.EOF)
+
+	// Test: HTTP/1.0 closes unless the client asks for keep-alive
+	resp = roundTripRaw(t, addr, "GET / HTTP/1.0\r\n\r\n")
+	assert.True(t, resp.Close)
+	resp = roundTripRaw(t, addr, "GET / HTTP/1.0\r\nConnection: keep-alive\r\n\r\n")
+	assert.Equal(t, "keep-alive", resp.Header.Get("Connection"))
+}
+
+func TestServerTimeouts (t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.ReadHeaderTimeout = 200 * time.Millisecond
+	cfg.ReadBodyTimeout = 200 * time.Millisecond
+	cfg.IdleTimeout = 200 * time.Millisecond
+
+	bodyErr := make(chan error, 1)
+	addr := startServer(t, cfg, func(w *response.Writer, req *request.Request) {
+		_, err := io.ReadAll(req.Body)
+		bodyErr <- err
+		okHandler(w, req)
+	})
+
+	// Test: Slow headers get a 408
+	conn, err := net.Dial("tcp", addr)
+	require.NoError(t, err)
+	defer conn.Close()
+	go slowClient(conn, "GET / HTTP/1.1\r\nHost: localhost\r\n\r\n", 1, 50*time.Millisecond)
+	resp, err := http.ReadResponse(bufio.NewReader(conn), nil)
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusRequestTimeout, resp.StatusCode)
+
+	// Test: Slow body fails the handler's read
+	conn, err = net.Dial("tcp", addr)
+	require.NoError(t, err)
+	defer conn.Close()
+	_, err = conn.Write([]byte("POST / HTTP/1.1\r\nHost: localhost\r\nContent-Length: 100\r\n\r\n"))
+	require.NoError(t, err)
+	go slowClient(conn, "hello", 1, 100*time.Millisecond)
+	select {
+	case err := <-bodyErr:
+		require.ErrorIs(t, err, os.ErrDeadlineExceeded)
+	case <-time.After(2 * time.Second):
+		t.Fatal("handler did not time out reading the body")
+	}
+
+	// Test: Idle keep-alive connection is closed
+	conn, err = net.Dial("tcp", addr)
+	require.NoError(t, err)
+	defer conn.Close()
+	_, err = conn.Write([]byte("GET / HTTP/1.1\r\nHost: localhost\r\n\r\n"))
+	require.NoError(t, err)
+	reader := bufio.NewReader(conn)
+	resp, err = http.ReadResponse(reader, nil)
+	require.NoError(t, err)
+	io.ReadAll(resp.Body)
+	<-bodyErr
+	start := time.Now()
+	_, err = reader.ReadByte()
+	require.ErrorIs(t, err, io.EOF)
+	assert.Less(t, time.Since(start), time.Second)
+}
+
+func TestServerShutdown (t *testing.T) {
+	started := make(chan struct{})
+	release := make(chan struct{})
+	s, err := ServeWithConfig(0, func(w *response.Writer, req *request.Request) {
+		if req.RequestLine.RequestTarget == "/slow" {
+			close(started)
+			<-release
+		}
+		okHandler(w, req)
+	}, DefaultConfig())
+	require.NoError(t, err)
+	addr := s.Addr().String()
+
+	// An idle keep-alive connection
+	idle, err := net.Dial("tcp", addr)
+	require.NoError(t, err)
+	defer idle.Close()
+	idleReader := bufio.NewReader(idle)
+	_, err = idle.Write([]byte("GET / HTTP/1.1\r\nHost: localhost\r\n\r\n"))
+	require.NoError(t, err)
+	resp, err := http.ReadResponse(idleReader, nil)
+	require.NoError(t, err)
+	io.ReadAll(resp.Body)
+
+	// A connection in the middle of a request
+	active, err := net.Dial("tcp", addr)
+	require.NoError(t, err)
+	defer active.Close()
+	_, err = active.Write([]byte("GET /slow HTTP/1.1\r\nHost: localhost\r\n\r\n"))
+	require.NoError(t, err)
+	<-started
+
+	shutdownErr := make(chan error, 1)
+	go func() { shutdownErr <- s.Shutdown(context.Background()) }()
+
+	// Test: Idle connection is closed
+	_, err = idleReader.ReadByte()
+	require.ErrorIs(t, err, io.EOF)
+
+	// Test: New connections are refused
+	_, err = net.Dial("tcp", addr)
+	require.Error(t, err)
+
+	// Test: Shutdown waits for the active request
+	select {
+	case <-shutdownErr:
+		t.Fatal("Shutdown returned before the active request finished")
+	case <-time.After(100 * time.Millisecond):
+	}
+	close(release)
+	activeReader := bufio.NewReader(active)
+	resp, err = http.ReadResponse(activeReader, nil)
+	require.NoError(t, err)
+	body, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	assert.Equal(t, "ok", string(body))
+	_, err = activeReader.ReadByte()
+	require.ErrorIs(t, err, io.EOF)
+	require.NoError(t, <-shutdownErr)
+}
+
+func TestServerShutdownNewConn (t *testing.T) {
+	s, err := ServeWithConfig(0, okHandler, DefaultConfig())
+	require.NoError(t, err)
+
+	// A connection whose first request is still on its way
+	conn, err := net.Dial("tcp", s.Addr().String())
+	require.NoError(t, err)
+	defer conn.Close()
+	require.Eventually(t, func() bool {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		return len(s.conns) == 1
+	}, time.Second, 10*time.Millisecond)
+
+	shutdownErr := make(chan error, 1)
+	go func() { shutdownErr <- s.Shutdown(context.Background()) }()
+
+	// Test: It is not taken for idle, and its request is served
+	time.Sleep(2 * shutdownPollInterval)
+	_, err = conn.Write([]byte("GET / HTTP/1.1\r\nHost: localhost\r\n\r\n"))
+	require.NoError(t, err)
+	resp, err := http.ReadResponse(bufio.NewReader(conn), nil)
+	require.NoError(t, err)
+	body, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	assert.Equal(t, "ok", string(body))
+	assert.True(t, resp.Close)
+	require.NoError(t, <-shutdownErr)
+}
+
+func TestServerShutdownTimeout (t *testing.T) {
+	release := make(chan struct{})
+	defer close(release)
+	started := make(chan struct{})
+	s, err := ServeWithConfig(0, func(w *response.Writer, req *request.Request) {
+		close(started)
+		<-release
+	}, DefaultConfig())
+	require.NoError(t, err)
+
+	conn, err := net.Dial("tcp", s.Addr().String())
+	require.NoError(t, err)
+	defer conn.Close()
+	_, err = conn.Write([]byte("GET / HTTP/1.1\r\nHost: localhost\r\n\r\n"))
+	require.NoError(t, err)
+	<-started
+
+	// Test: Expired context force-closes active connections
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	err = s.Shutdown(ctx)
+	require.ErrorIs(t, err, context.DeadlineExceeded)
+	_, err = conn.Read(make([]byte, 1))
+	require.Error(t, err)
+}
+
+func TestServerExpectContinue (t *testing.T) {
+	addr := startServer(t, DefaultConfig(), func(w *response.Writer, req *request.Request) {
+		if req.RequestLine.RequestTarget == "/reject" {
+			w.WriteStatusLine(response.StatusCodeContentTooLarge)
+			w.WriteHeaders(response.GetDefaultHeaders(0))
+			w.WriteBody(nil)
+			return
+		}
+		body, _ := io.ReadAll(req.Body)
+		w.WriteStatusLine(response.StatusCodeSuccess)
+		w.WriteHeaders(response.GetDefaultHeaders(len(body)))
+		w.WriteBody(body)
+	})
+
+	// Test: 100 Continue is sent once the handler reads the body
+	conn, err := net.Dial("tcp", addr)
+	require.NoError(t, err)
+	defer conn.Close()
+	reader := bufio.NewReader(conn)
+	_, err = conn.Write([]byte("POST / HTTP/1.1\r\nHost: localhost\r\nContent-Length: 5\r\nExpect: 100-continue\r\n\r\n"))
+	require.NoError(t, err)
+	line, err := reader.ReadString('\n')
+	require.NoError(t, err)
+	assert.Equal(t, "HTTP/1.1 100 Continue\r\n", line)
+	line, err = reader.ReadString('\n')
+	require.NoError(t, err)
+	assert.Equal(t, "\r\n", line)
+	_, err = conn.Write([]byte("hello"))
+	require.NoError(t, err)
+	resp, err := http.ReadResponse(reader, nil)
+	require.NoError(t, err)
+	body, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	assert.Equal(t, "hello", string(body))
+	assert.False(t, resp.Close)
+
+	// Test: Handler rejects without reading, no 100 Continue and no reuse
+	conn, err = net.Dial("tcp", addr)
+	require.NoError(t, err)
+	defer conn.Close()
+	reader = bufio.NewReader(conn)
+	_, err = conn.Write([]byte("POST /reject HTTP/1.1\r\nHost: localhost\r\nContent-Length: 5\r\nExpect: 100-continue\r\n\r\n"))
+	require.NoError(t, err)
+	resp, err = http.ReadResponse(reader, nil)
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusRequestEntityTooLarge, resp.StatusCode)
+	assert.True(t, resp.Close)
+
+	// Test: Unknown expectation gets 417
+	conn, err = net.Dial("tcp", addr)
+	require.NoError(t, err)
+	defer conn.Close()
+	_, err = conn.Write([]byte("POST / HTTP/1.1\r\nHost: localhost\r\nContent-Length: 5\r\nExpect: something-else\r\n\r\n"))
+	require.NoError(t, err)
+	resp, err = http.ReadResponse(bufio.NewReader(conn), nil)
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusExpectationFailed, resp.StatusCode)
+}
+
+func TestServerErrorResponses (t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.MaxRequestLineBytes = 64
+	cfg.MaxHeaderBytes = 256
+	cfg.MaxBodyBytes = 10
+	cfg.AllowedMethods = []string{"GET", "POST"}
+	addr := startServer(t, cfg, okHandler)
+
+	// Test: Each parse failure gets its own status, and the body does not
+	// leak the parser's error
+	tests := []struct {
+		raw    string
+		status int
+	}{
+		{"GET /\r\n\r\n", http.StatusBadRequest},
+		{"GET / HTTP/1.1\r\nHost localhost\r\n\r\n", http.StatusBadRequest},
+		{"PUT / HTTP/1.1\r\nHost: localhost\r\n\r\n", http.StatusMethodNotAllowed},
+		{"GET / HTTP/2.0\r\nHost: localhost\r\n\r\n", http.StatusHTTPVersionNotSupported},
+		{"GET /" + strings.Repeat("a", 100) + " HTTP/1.1\r\nHost: localhost\r\n\r\n", http.StatusRequestURITooLong},
+		{"POST / HTTP/1.1\r\nHost: localhost\r\nTransfer-Encoding: gzip\r\n\r\n", http.StatusNotImplemented},
+		{"GET / HTTP/1.1\r\nHost: localhost\r\nCookie: " + strings.Repeat("c", 300) + "\r\n\r\n", http.StatusRequestHeaderFieldsTooLarge},
+		{"POST / HTTP/1.1\r\nHost: localhost\r\nContent-Length: 11\r\n\r\nhello world", http.StatusRequestEntityTooLarge},
+	}
+	for _, tt := range tests {
+		resp := roundTripRaw(t, addr, tt.raw)
+		body, err := io.ReadAll(resp.Body)
+		require.NoError(t, err)
+		assert.Equal(t, tt.status, resp.StatusCode, tt.raw)
+		assert.Equal(t, fmt.Sprintf("%d %s\n", tt.status, response.StatusText(response.StatusCode(tt.status))), string(body))
+		assert.True(t, resp.Close)
+	}
+
+	// Test: A chunked body found too large while the handler reads it
+	addr = startServer(t, cfg, func(w *response.Writer, req *request.Request) {
+		if _, err := io.ReadAll(req.Body); err != nil {
+			w.WriteHeader(response.StatusCodeBadRequest)
+			return
+		}
+		okHandler(w, req)
+	})
+	resp := roundTripRaw(t, addr, "POST / HTTP/1.1\r\nHost: localhost\r\nTransfer-Encoding: chunked\r\n\r\n6\r\nhello \r\n6\r\nworld!\r\n0\r\n\r\n")
+	assert.Equal(t, http.StatusRequestEntityTooLarge, resp.StatusCode)
+	assert.True(t, resp.Close)
+
+	// Test: 405 lists the allowed methods
+	resp = roundTripRaw(t, addr, "PUT / HTTP/1.1\r\nHost: localhost\r\n\r\n")
+	assert.Equal(t, "GET, POST", resp.Header.Get("Allow"))
+
+	// Test: Custom error page
+	var gotErr error
+	cfg.ErrorPage = func(statusCode response.StatusCode, err error) (string, []byte) {
+		gotErr = err
+		return "application/json", []byte(fmt.Sprintf(`{"status":%d}`, statusCode))
+	}
+	addr = startServer(t, cfg, okHandler)
+	resp = roundTripRaw(t, addr, "GET / HTTP/2.0\r\nHost: localhost\r\n\r\n")
+	body, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	assert.Equal(t, "application/json", resp.Header.Get("Content-Type"))
+	assert.Equal(t, `{"status":505}`, string(body))
+	assert.ErrorIs(t, gotErr, request.ErrUnsupportedVersion)
+}
+
+func TestServerHTTP10 (t *testing.T) {
+	addr := startServer(t, DefaultConfig(), okHandler)
+
+	// Test: HTTP/1.0 closes by default
+	conn, err := net.Dial("tcp", addr)
+	require.NoError(t, err)
+	defer conn.Close()
+	reader := bufio.NewReader(conn)
+	_, err = conn.Write([]byte("GET / HTTP/1.0\r\n\r\n"))
+	require.NoError(t, err)
+	resp, err := http.ReadResponse(reader, nil)
+	require.NoError(t, err)
+	io.ReadAll(resp.Body)
+	assert.Equal(t, "HTTP/1.0", resp.Proto)
+	_, err = reader.ReadByte()
+	require.ErrorIs(t, err, io.EOF)
+
+	// Test: HTTP/1.0 keep-alive on request
+	conn, err = net.Dial("tcp", addr)
+	require.NoError(t, err)
+	defer conn.Close()
+	reader = bufio.NewReader(conn)
+	for i := 0; i < 2; i++ {
+		_, err = conn.Write([]byte("GET / HTTP/1.0\r\nConnection: keep-alive\r\n\r\n"))
+		require.NoError(t, err)
+		resp, err = http.ReadResponse(reader, nil)
+		require.NoError(t, err)
+		io.ReadAll(resp.Body)
+		assert.Equal(t, "keep-alive", resp.Header.Get("Connection"))
+	}
+
+	// Test: HTTP/1.1 without Host gets a 400
+	resp = roundTripRaw(t, addr, "GET / HTTP/1.1\r\n\r\n")
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+}
+
+func TestServerPipelinedHead (t *testing.T) {
+	addr := startServer(t, DefaultConfig(), func(w *response.Writer, req *request.Request) {
+		switch req.RequestLine.RequestTarget {
+		case "/missing":
+			body := []byte("404 Not Found\n")
+			w.WriteStatusLine(response.StatusCodeNotFound)
+			w.WriteHeaders(response.GetDefaultHeaders(len(body)))
+			w.WriteBody(body)
+		case "/chunked":
+			h := headers.NewHeaders()
+			h.Set("Transfer-Encoding", "chunked")
+			w.WriteStatusLine(response.StatusCodeSuccess)
+			w.WriteHeaders(h)
+			w.WriteChunkedBody([]byte("hello"))
+			w.WriteChunkedBodyDone()
+			w.WriteTrailers(headers.NewHeaders())
+		default:
+			okHandler(w, req)
+		}
+	})
+	conn, err := net.Dial("tcp", addr)
+	require.NoError(t, err)
+	defer conn.Close()
+	reader := bufio.NewReader(conn)
+
+	// Test: Responses to HEAD written with the low-level methods have no
+	// body, so the next response on the connection is intact
+	_, err = conn.Write([]byte("HEAD /missing HTTP/1.1\r\nHost: localhost\r\n\r\n" +
+		"HEAD /chunked HTTP/1.1\r\nHost: localhost\r\n\r\n" +
+		"GET /a HTTP/1.1\r\nHost: localhost\r\n\r\n"))
+	require.NoError(t, err)
+	for _, want := range []int{http.StatusNotFound, http.StatusOK} {
+		resp, err := http.ReadResponse(reader, &http.Request{Method: "HEAD"})
+		require.NoError(t, err)
+		assert.Equal(t, want, resp.StatusCode)
+	}
+	resp, err := http.ReadResponse(reader, nil)
+	require.NoError(t, err)
+	body, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, "ok", string(body))
+}
+
+func TestServerTrailers (t *testing.T) {
+	addr := startServer(t, DefaultConfig(), func(w *response.Writer, req *request.Request) {
+		// Read the body through a copy, as a handler behind middleware would
+		inner := req.WithContext(context.Background())
+		body, _ := io.ReadAll(inner.Body)
+		checksum, _ := req.Trailers.Get("X-Checksum")
+		fmt.Fprintf(w, "%s %s", body, checksum)
+	})
+
+	// Test: Trailers read through one copy of the request show in the other
+	resp := roundTripRaw(t, addr, "POST / HTTP/1.1\r\nHost: localhost\r\nTransfer-Encoding: chunked\r\n\r\n"+
+		"5\r\nhello\r\n0\r\nX-Checksum: abc\r\n\r\n")
+	body, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	assert.Equal(t, "hello abc", string(body))
+}
+
+func TestServerAutoFraming (t *testing.T) {
+	addr := startServer(t, DefaultConfig(), func(w *response.Writer, req *request.Request) {
+		w.Header().Set("Content-Type", "text/plain")
+		io.WriteString(w, "hello")
+		if req.RequestLine.Target.Path == "/stream" {
+			w.Flush()
+			io.WriteString(w, " world")
+		}
+	})
+	conn, err := net.Dial("tcp", addr)
+	require.NoError(t, err)
+	defer conn.Close()
+	reader := bufio.NewReader(conn)
+
+	// Test: Buffered, chunked and HEAD responses on one connection
+	tests := []struct {
+		method        string
+		path          string
+		body          string
+		contentLength int64
+	}{
+		{"GET", "/", "hello", 5},
+		{"GET", "/stream", "hello world", -1},
+		{"HEAD", "/", "", 5},
+	}
+	for _, tt := range tests {
+		_, err = fmt.Fprintf(conn, "%s %s HTTP/1.1\r\nHost: localhost\r\n\r\n", tt.method, tt.path)
+		require.NoError(t, err)
+		resp, err := http.ReadResponse(reader, &http.Request{Method: tt.method})
+		require.NoError(t, err)
+		body, err := io.ReadAll(resp.Body)
+		require.NoError(t, err)
+		assert.Equal(t, tt.body, string(body))
+		assert.Equal(t, tt.contentLength, resp.ContentLength)
+		assert.False(t, resp.Close)
+	}
+}
+
+func TestServerPanicRecovery (t *testing.T) {
+	log.SetOutput(io.Discard)
+	t.Cleanup(func() { log.SetOutput(os.Stderr) })
+
+	addr := startServer(t, DefaultConfig(), func(w *response.Writer, req *request.Request) {
+		switch req.RequestLine.Target.Path {
+		case "/before":
+			io.WriteString(w, "partial")
+			panic("boom")
+		case "/after":
+			w.Header().Set("Content-Length", "100")
+			io.WriteString(w, "partial")
+			w.Flush()
+			panic("boom")
+		case "/chunked":
+			io.WriteString(w, "partial")
+			w.Flush()
+			panic(ErrAbortHandler)
+		}
+		okHandler(w, req)
+	})
+
+	// Test: Panic before anything was sent gets a 500 and closes
+	resp := roundTripRaw(t, addr, "GET /before HTTP/1.1\r\nHost: localhost\r\n\r\n")
+	body, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusInternalServerError, resp.StatusCode)
+	assert.Equal(t, "500 Internal Server Error\n", string(body))
+	assert.True(t, resp.Close)
+
+	// Test: Panic mid-response leaves the body visibly truncated
+	for _, path := range []string{"/after", "/chunked"} {
+		resp = roundTripRaw(t, addr, "GET "+path+" HTTP/1.1\r\nHost: localhost\r\n\r\n")
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+		_, err = io.ReadAll(resp.Body)
+		require.Error(t, err, path)
+	}
+
+	// Test: Server keeps serving other connections
+	resp = roundTripRaw(t, addr, "GET / HTTP/1.1\r\nHost: localhost\r\n\r\n")
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+}
+
+func TestServerRequestContext (t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.WriteTimeout = 300 * time.Millisecond
+	causes := make(chan error, 1)
+	started := make(chan struct{}, 1)
+	s, err := ServeWithConfig(0, func(w *response.Writer, req *request.Request) {
+		io.ReadAll(req.Body)
+		started <- struct{}{}
+		<-req.Context().Done()
+		causes <- context.Cause(req.Context())
+	}, cfg)
+	require.NoError(t, err)
+	defer s.Close()
+	addr := s.Addr().String()
+
+	// Test: Client disconnecting cancels the handler
+	conn, err := net.Dial("tcp", addr)
+	require.NoError(t, err)
+	_, err = conn.Write([]byte("POST / HTTP/1.1\r\nHost: localhost\r\nContent-Length: 5\r\n\r\nhello"))
+	require.NoError(t, err)
+	<-started
+	conn.Close()
+	select {
+	case cause := <-causes:
+		require.ErrorIs(t, cause, ErrClientDisconnected)
+	case <-time.After(time.Second):
+		t.Fatal("context not cancelled on disconnect")
+	}
+
+	// Test: Write timeout expiring cancels the handler
+	conn, err = net.Dial("tcp", addr)
+	require.NoError(t, err)
+	defer conn.Close()
+	_, err = conn.Write([]byte("GET / HTTP/1.1\r\nHost: localhost\r\n\r\n"))
+	require.NoError(t, err)
+	<-started
+	select {
+	case cause := <-causes:
+		require.ErrorIs(t, cause, ErrHandlerTimeout)
+	case <-time.After(time.Second):
+		t.Fatal("context not cancelled on timeout")
+	}
+
+	// Test: Close cancels running handlers
+	cfg.WriteTimeout = 0
+	s2, err := ServeWithConfig(0, func(w *response.Writer, req *request.Request) {
+		started <- struct{}{}
+		<-req.Context().Done()
+		causes <- context.Cause(req.Context())
+	}, cfg)
+	require.NoError(t, err)
+	conn, err = net.Dial("tcp", s2.Addr().String())
+	require.NoError(t, err)
+	defer conn.Close()
+	_, err = conn.Write([]byte("GET / HTTP/1.1\r\nHost: localhost\r\n\r\n"))
+	require.NoError(t, err)
+	<-started
+	s2.Close()
+	select {
+	case cause := <-causes:
+		require.ErrorIs(t, cause, ErrServerClosed)
+	case <-time.After(time.Second):
+		t.Fatal("context not cancelled on Close")
+	}
+}
+
+func TestServerPipelinedNotCancelled (t *testing.T) {
+	// Test: A pipelined request arriving does not look like a disconnect
+	var errs []error
+	addr := startServer(t, DefaultConfig(), func(w *response.Writer, req *request.Request) {
+		time.Sleep(50 * time.Millisecond)
+		errs = append(errs, req.Context().Err())
+		okHandler(w, req)
+	})
+	conn, err := net.Dial("tcp", addr)
+	require.NoError(t, err)
+	defer conn.Close()
+	_, err = conn.Write([]byte("GET /a HTTP/1.1\r\nHost: localhost\r\n\r\nGET /b HTTP/1.1\r\nHost: localhost\r\n\r\n"))
+	require.NoError(t, err)
+	reader := bufio.NewReader(conn)
+	for i := 0; i < 2; i++ {
+		resp, err := http.ReadResponse(reader, nil)
+		require.NoError(t, err)
+		io.ReadAll(resp.Body)
+	}
+	assert.Equal(t, []error{nil, nil}, errs)
+}
@@ -0,0 +1,215 @@
+package server
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ServeTLS serves HTTPS with the default configuration and a single
+// certificate, which is reloaded whenever its files change.
+func ServeTLS(port int, handler Handler, certFile, keyFile string) (*Server, error) {
+	certs := NewCertStore()
+	if err := certs.Add(certFile, keyFile); err != nil {
+		return nil, err
+	}
+	cfg := DefaultConfig()
+	cfg.TLSConfig = certs.TLSConfig()
+	s, err := ServeWithConfig(port, handler, cfg)
+	if err != nil {
+		return nil, err
+	}
+	go certs.Watch(s.ctx, certReloadInterval)
+	return s, nil
+}
+
+// certReloadInterval is how often ServeTLS checks its certificate files for
+// changes.
+const certReloadInterval = 10 * time.Second
+
+// CertStore holds certificates for several host names and picks one by the
+// server name the client asks for (SNI). The first certificate added is
+// the default, for clients that send no name or an unknown one.
+type CertStore struct {
+	mu    sync.RWMutex
+	pairs []*certPair
+	names map[string]*certPair
+}
+
+type certPair struct {
+	certFile, keyFile string
+	cert              *tls.Certificate
+	modTime           time.Time // latest of the two files
+}
+
+func NewCertStore() *CertStore {
+	return &CertStore{names: make(map[string]*certPair)}
+}
+
+// Add loads a certificate and its key from PEM files. It serves the DNS
+// names of the certificate, including wildcards such as "*.example.com".
+func (cs *CertStore) Add(certFile, keyFile string) error {
+	pair := &certPair{certFile: certFile, keyFile: keyFile}
+	if err := pair.load(); err != nil {
+		return err
+	}
+	cs.mu.Lock()
+	defer cs.mu.Unlock()
+	cs.pairs = append(cs.pairs, pair)
+	cs.index()
+	return nil
+}
+
+func (p *certPair) load() error {
+	modTime, err := p.stat()
+	if err != nil {
+		return err
+	}
+	cert, err := tls.LoadX509KeyPair(p.certFile, p.keyFile)
+	if err != nil {
+		return fmt.Errorf("error loading certificate %s: %w", p.certFile, err)
+	}
+	if cert.Leaf == nil {
+		cert.Leaf, err = x509.ParseCertificate(cert.Certificate[0])
+		if err != nil {
+			return fmt.Errorf("error parsing certificate %s: %w", p.certFile, err)
+		}
+	}
+	p.cert = &cert
+	p.modTime = modTime
+	return nil
+}
+
+func (p *certPair) stat() (time.Time, error) {
+	var latest time.Time
+	for _, name := range []string{p.certFile, p.keyFile} {
+		info, err := os.Stat(name)
+		if err != nil {
+			return time.Time{}, err
+		}
+		if info.ModTime().After(latest) {
+			latest = info.ModTime()
+		}
+	}
+	return latest, nil
+}
+
+// index maps host names to certificates. Earlier pairs win when two claim
+// the same name. The caller holds mu.
+func (cs *CertStore) index() {
+	names := make(map[string]*certPair)
+	for _, pair := range cs.pairs {
+		leaf := pair.cert.Leaf
+		hostNames := leaf.DNSNames
+		if len(hostNames) == 0 && leaf.Subject.CommonName != "" {
+			hostNames = []string{leaf.Subject.CommonName}
+		}
+		for _, name := range hostNames {
+			name = strings.ToLower(name)
+			if _, ok := names[name]; !ok {
+				names[name] = pair
+			}
+		}
+	}
+	cs.names = names
+}
+
+// GetCertificate implements tls.Config.GetCertificate: an exact match on
+// the server name first, then a wildcard for its first label, then the
+// default certificate.
+func (cs *CertStore) GetCertificate(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+	cs.mu.RLock()
+	defer cs.mu.RUnlock()
+	if len(cs.pairs) == 0 {
+		return nil, fmt.Errorf("no certificates")
+	}
+	name := strings.ToLower(strings.TrimSuffix(hello.ServerName, "."))
+	if pair, ok := cs.names[name]; ok {
+		return pair.cert, nil
+	}
+	if _, rest, ok := strings.Cut(name, "."); ok {
+		if pair, ok := cs.names["*."+rest]; ok {
+			return pair.cert, nil
+		}
+	}
+	return cs.pairs[0].cert, nil
+}
+
+// TLSConfig returns a configuration serving the store's certificates over
+// TLS 1.2 or later.
+func (cs *CertStore) TLSConfig() *tls.Config {
+	return &tls.Config{
+		GetCertificate: cs.GetCertificate,
+		MinVersion:     tls.VersionTLS12,
+		NextProtos:     []string{"http/1.1"},
+	}
+}
+
+// Reload reloads every certificate whose files have changed since they were
+// last loaded. A certificate that fails to load keeps being served as
+// before, and the first such error is returned.
+func (cs *CertStore) Reload() error {
+	cs.mu.RLock()
+	pairs := append([]*certPair(nil), cs.pairs...)
+	cs.mu.RUnlock()
+
+	var firstErr error
+	var reloaded []*certPair
+	for _, pair := range pairs {
+		modTime, err := pair.stat()
+		if err == nil && !modTime.After(pair.modTime) {
+			continue
+		}
+		next := &certPair{certFile: pair.certFile, keyFile: pair.keyFile}
+		if err == nil {
+			err = next.load()
+		}
+		if err != nil {
+			if firstErr == nil {
+				firstErr = err
+			}
+			continue
+		}
+		reloaded = append(reloaded, pair, next)
+	}
+	if len(reloaded) == 0 {
+		return firstErr
+	}
+
+	cs.mu.Lock()
+	defer cs.mu.Unlock()
+	for i := 0; i < len(reloaded); i += 2 {
+		old, next := reloaded[i], reloaded[i+1]
+		for j, pair := range cs.pairs {
+			if pair == old {
+				cs.pairs[j] = next
+			}
+		}
+	}
+	cs.index()
+	return firstErr
+}
+
+// Watch calls Reload every interval until ctx is done, logging failures.
+// Files are polled rather than watched so that it works the same on every
+// platform and with certificates replaced by renaming.
+func (cs *CertStore) Watch(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := cs.Reload(); err != nil {
+				log.Printf("Error reloading certificates: %v", err)
+			}
+		}
+	}
+}
@@ -0,0 +1,141 @@
+package server
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io"
+	"math/big"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"httpfromtcp/internal/request"
+	"httpfromtcp/internal/response"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// writeCert generates a self-signed certificate for names into dir and
+// returns the paths of the certificate and key files
+func writeCert(t *testing.T, dir, prefix string, serial int64, names ...string) (string, string) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(serial),
+		Subject:               pkix.Name{CommonName: names[0]},
+		DNSNames:              names,
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	require.NoError(t, err)
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	require.NoError(t, err)
+
+	certFile := filepath.Join(dir, prefix+".crt")
+	keyFile := filepath.Join(dir, prefix+".key")
+	require.NoError(t, os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0o600))
+	require.NoError(t, os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0o600))
+	return certFile, keyFile
+}
+
+// peerCert does a TLS handshake asking for serverName and returns the
+// certificate the server chose
+func peerCert(t *testing.T, addr, serverName string) *x509.Certificate {
+	t.Helper()
+	conn, err := tls.Dial("tcp", addr, &tls.Config{ServerName: serverName, InsecureSkipVerify: true})
+	require.NoError(t, err)
+	defer conn.Close()
+	return conn.ConnectionState().PeerCertificates[0]
+}
+
+func TestServeTLS (t *testing.T) {
+	certFile, keyFile := writeCert(t, t.TempDir(), "localhost", 1, "localhost")
+	var tlsState *tls.ConnectionState
+	s, err := ServeTLS(0, func(w *response.Writer, req *request.Request) {
+		tlsState = req.TLS
+		okHandler(w, req)
+	}, certFile, keyFile)
+	require.NoError(t, err)
+	defer s.Close()
+
+	// Test: Client trusting the certificate gets a response
+	pemData, err := os.ReadFile(certFile)
+	require.NoError(t, err)
+	pool := x509.NewCertPool()
+	require.True(t, pool.AppendCertsFromPEM(pemData))
+	client := &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{RootCAs: pool}}}
+	defer client.CloseIdleConnections()
+	_, port, _ := net.SplitHostPort(s.Addr().String())
+	resp, err := client.Get("https://localhost:" + port + "/")
+	require.NoError(t, err)
+	body, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	require.NoError(t, err)
+	assert.Equal(t, "ok", string(body))
+
+	// Test: Handler sees the negotiated TLS state
+	require.NotNil(t, tlsState)
+	assert.Equal(t, "localhost", tlsState.ServerName)
+	assert.True(t, tlsState.HandshakeComplete)
+}
+
+func TestCertStoreSNI (t *testing.T) {
+	dir := t.TempDir()
+	certs := NewCertStore()
+	require.NoError(t, certs.Add(writeCert(t, dir, "a", 1, "a.test")))
+	require.NoError(t, certs.Add(writeCert(t, dir, "b", 2, "b.test", "*.b.test")))
+	cfg := DefaultConfig()
+	cfg.TLSConfig = certs.TLSConfig()
+	addr := startServer(t, cfg, okHandler)
+
+	// Test: Certificate chosen by server name, wildcard, or default
+	assert.Equal(t, int64(1), peerCert(t, addr, "a.test").SerialNumber.Int64())
+	assert.Equal(t, int64(2), peerCert(t, addr, "B.TEST").SerialNumber.Int64())
+	assert.Equal(t, int64(2), peerCert(t, addr, "www.b.test").SerialNumber.Int64())
+	assert.Equal(t, int64(1), peerCert(t, addr, "x.www.b.test").SerialNumber.Int64())
+	assert.Equal(t, int64(1), peerCert(t, addr, "unknown.test").SerialNumber.Int64())
+}
+
+func TestCertStoreReload (t *testing.T) {
+	dir := t.TempDir()
+	certFile, keyFile := writeCert(t, dir, "site", 1, "site.test")
+	certs := NewCertStore()
+	require.NoError(t, certs.Add(certFile, keyFile))
+	cfg := DefaultConfig()
+	cfg.TLSConfig = certs.TLSConfig()
+	addr := startServer(t, cfg, okHandler)
+	assert.Equal(t, int64(1), peerCert(t, addr, "site.test").SerialNumber.Int64())
+
+	// Test: Unchanged files are not reloaded
+	require.NoError(t, certs.Reload())
+	assert.Equal(t, int64(1), peerCert(t, addr, "site.test").SerialNumber.Int64())
+
+	// Test: Replaced certificate is served without a restart
+	writeCert(t, dir, "site", 2, "site.test")
+	later := time.Now().Add(time.Minute)
+	require.NoError(t, os.Chtimes(certFile, later, later))
+	require.NoError(t, os.Chtimes(keyFile, later, later))
+	require.NoError(t, certs.Reload())
+	assert.Equal(t, int64(2), peerCert(t, addr, "site.test").SerialNumber.Int64())
+
+	// Test: Broken replacement keeps the previous certificate
+	require.NoError(t, os.WriteFile(certFile, []byte("garbage"), 0o600))
+	later = later.Add(time.Minute)
+	require.NoError(t, os.Chtimes(certFile, later, later))
+	require.Error(t, certs.Reload())
+	assert.Equal(t, int64(2), peerCert(t, addr, "site.test").SerialNumber.Int64())
+}